@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// secret returns the inline value or, if a file is given, the content of
+// the file. The file is read every time so that rotated secrets are picked
+// up without a restart.
+func secret(value string, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("error reading secret file %v: %v", file, err))
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// cloneRequest returns a copy of the request with its own headers, round
+// trippers must not modify the request they are given
+func cloneRequest(req *http.Request) *http.Request {
+	clone := req.Clone(req.Context())
+	clone.Header = req.Header.Clone()
+	return clone
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// get sends a request through the round tripper and returns the
+// Authorization header the server received
+func get(t *testing.T, rt http.RoundTripper, url string) string {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if req.Header.Get("Authorization") != "" {
+		t.Error("the original request was modified")
+	}
+	return resp.Header.Get("X-Authorization")
+}
+
+// echo responds with the Authorization header of the request
+func echo() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Authorization", req.Header.Get("Authorization"))
+	}))
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	server := echo()
+	defer server.Close()
+
+	// user:secret and user:rotated
+	got := get(t, NewBasicAuth("user", "secret", "", http.DefaultTransport), server.URL)
+	if got != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("unexpected header %q", got)
+	}
+
+	file := filepath.Join(t.TempDir(), "password")
+	writeFile(t, file, "secret\n")
+	rt := NewBasicAuth("user", "", file, http.DefaultTransport)
+	if got := get(t, rt, server.URL); got != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("unexpected header %q", got)
+	}
+	writeFile(t, file, "rotated")
+	if got := get(t, rt, server.URL); got != "Basic dXNlcjpyb3RhdGVk" {
+		t.Errorf("expected the rotated password, got %q", got)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	server := echo()
+	defer server.Close()
+
+	got := get(t, NewAuthorization("", "token", "", http.DefaultTransport), server.URL)
+	if got != "Bearer token" {
+		t.Errorf("unexpected header %q", got)
+	}
+
+	file := filepath.Join(t.TempDir(), "credentials")
+	writeFile(t, file, "first")
+	rt := NewAuthorization("Custom", "", file, http.DefaultTransport)
+	if got := get(t, rt, server.URL); got != "Custom first" {
+		t.Errorf("unexpected header %q", got)
+	}
+	writeFile(t, file, "second")
+	if got := get(t, rt, server.URL); got != "Custom second" {
+		t.Errorf("expected the rotated credentials, got %q", got)
+	}
+
+	_, err := NewAuthorization("", "", filepath.Join(t.TempDir(), "missing"), http.DefaultTransport).RoundTrip(httptest.NewRequest("GET", server.URL, nil))
+	if err == nil {
+		t.Error("expected an error for a missing credentials file")
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// DefaultAuthorizationType is the type of bearer tokens
+const DefaultAuthorizationType = "Bearer"
+
+// Authorization sets the Authorization header to a type and credentials,
+// e.g. a bearer token
+type Authorization struct {
+	authType        string
+	credentials     string
+	credentialsFile string
+	next            http.RoundTripper
+}
+
+func NewAuthorization(authType string, credentials string, credentialsFile string, next http.RoundTripper) *Authorization {
+	if authType == "" {
+		authType = DefaultAuthorizationType
+	}
+	return &Authorization{
+		authType:        authType,
+		credentials:     credentials,
+		credentialsFile: credentialsFile,
+		next:            next,
+	}
+}
+
+func (a *Authorization) RoundTrip(req *http.Request) (*http.Response, error) {
+	credentials, err := secret(a.credentials, a.credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", a.authType+" "+credentials)
+	return a.next.RoundTrip(req)
+}
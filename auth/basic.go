@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// BasicAuth adds basic auth credentials to requests
+type BasicAuth struct {
+	username     string
+	password     string
+	passwordFile string
+	next         http.RoundTripper
+}
+
+func NewBasicAuth(username string, password string, passwordFile string, next http.RoundTripper) *BasicAuth {
+	return &BasicAuth{
+		username:     username,
+		password:     password,
+		passwordFile: passwordFile,
+		next:         next,
+	}
+}
+
+func (b *BasicAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	password, err := secret(b.password, b.passwordFile)
+	if err != nil {
+		return nil, err
+	}
+	req = cloneRequest(req)
+	req.SetBasicAuth(b.username, password)
+	return b.next.RoundTrip(req)
+}
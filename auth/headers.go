@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// Headers sets custom headers on requests, e.g. a tenant or an API key.
+// Headers the request already has are kept, so the tenant of a series takes
+// precedence over a custom X-Scope-OrgID header.
+type Headers struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func NewHeaders(headers map[string]string, next http.RoundTripper) *Headers {
+	return &Headers{
+		headers: headers,
+		next:    next,
+	}
+}
+
+func (h *Headers) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	for name, value := range h.headers {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+	return h.next.RoundTrip(req)
+}
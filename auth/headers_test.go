@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeadersKeepRequestHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Authorization", req.Header.Get("X-Scope-OrgID")+","+req.Header.Get("X-Api-Key"))
+	}))
+	defer server.Close()
+
+	rt := NewHeaders(map[string]string{"X-Scope-OrgID": "default", "x-api-key": "key"}, http.DefaultTransport)
+	for _, test := range []struct {
+		tenant   string
+		expected string
+	}{
+		{tenant: "", expected: "default,key"},
+		{tenant: "team-a", expected: "team-a,key"},
+	} {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.tenant != "" {
+			req.Header.Set("X-Scope-OrgID", test.tenant)
+		}
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Authorization"); got != test.expected {
+			t.Errorf("tenant %q: expected %q, got %q", test.tenant, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expiryDelta is how long before its expiry a token is refreshed
+const expiryDelta = 10 * time.Second
+
+// OAuth2 fetches an access token with the client credentials grant and adds
+// it to requests as a bearer token. The token is cached until it expires.
+type OAuth2 struct {
+	clientID         string
+	clientSecret     string
+	clientSecretFile string
+	tokenURL         string
+	scopes           []string
+	endpointParams   map[string]string
+	next             http.RoundTripper
+
+	mtx     sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func NewOAuth2(clientID string, clientSecret string, clientSecretFile string, tokenURL string, scopes []string, endpointParams map[string]string, next http.RoundTripper) *OAuth2 {
+	return &OAuth2{
+		clientID:         clientID,
+		clientSecret:     clientSecret,
+		clientSecretFile: clientSecretFile,
+		tokenURL:         tokenURL,
+		scopes:           scopes,
+		endpointParams:   endpointParams,
+		next:             next,
+	}
+}
+
+func (o *OAuth2) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := o.accessToken(req)
+	if err != nil {
+		return nil, err
+	}
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return o.next.RoundTrip(req)
+}
+
+func (o *OAuth2) accessToken(req *http.Request) (string, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if o.token != "" && (o.expires.IsZero() || time.Now().Before(o.expires)) {
+		return o.token, nil
+	}
+
+	clientSecret, err := secret(o.clientSecret, o.clientSecretFile)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(o.scopes) > 0 {
+		form.Set("scope", strings.Join(o.scopes, " "))
+	}
+	for name, value := range o.endpointParams {
+		form.Set(name, value)
+	}
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), "POST", o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(clientSecret))
+
+	resp, err := o.next.RoundTrip(tokenReq)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("error fetching oauth2 token: %v", err))
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", errors.New(fmt.Sprintf("unexpected oauth2 token status code: %v: %v", resp.StatusCode, strings.TrimSpace(string(body))))
+	}
+
+	token := tokenResponse{}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("error parsing oauth2 token: %v", err))
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("oauth2 token response contains no access token")
+	}
+
+	o.token = token.AccessToken
+	o.expires = time.Time{}
+	if token.ExpiresIn > 0 {
+		o.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - expiryDelta)
+	}
+	return o.token, nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOAuth2(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		expiresIn int
+		// tokens are the expected tokens of three requests
+		tokens []string
+	}{
+		{name: "cached", expiresIn: 3600, tokens: []string{"token-1", "token-1", "token-1"}},
+		// tokens are refreshed 10s before they expire
+		{name: "refreshed", expiresIn: 10, tokens: []string{"token-1", "token-2", "token-3"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var issued atomic.Int64
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				req.ParseForm()
+				user, password, _ := req.BasicAuth()
+				if req.Method != "POST" || req.Form.Get("grant_type") != "client_credentials" || req.Form.Get("scope") != "read write" || req.Form.Get("audience") != "test" {
+					http.Error(w, "invalid request", http.StatusBadRequest)
+					return
+				}
+				if user != "client" || password != "rotated" {
+					http.Error(w, "invalid client", http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"access_token":"token-%v","token_type":"Bearer","expires_in":%v}`, issued.Add(1), test.expiresIn)
+			}))
+			defer tokenServer.Close()
+			server := echo()
+			defer server.Close()
+
+			file := filepath.Join(t.TempDir(), "secret")
+			writeFile(t, file, "secret")
+			rt := NewOAuth2("client", "", file, tokenServer.URL, []string{"read", "write"}, map[string]string{"audience": "test"}, http.DefaultTransport)
+
+			// the token endpoint rejects the old secret
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			_, err := rt.RoundTrip(req)
+			if err == nil {
+				t.Fatal("expected an error for an invalid client secret")
+			}
+
+			writeFile(t, file, "rotated")
+			for i, token := range test.tokens {
+				if got := get(t, rt, server.URL); got != "Bearer "+token {
+					t.Errorf("request %v: expected %q, got %q", i, "Bearer "+token, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SigV4Service is the signing name of Amazon Managed Service for
+	// Prometheus
+	SigV4Service = "aps"
+
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+)
+
+// Credentials are AWS access keys, the session token is only set for
+// temporary credentials
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Expires is zero for long term credentials
+	Expires time.Time
+}
+
+// SigV4 signs requests with AWS Signature Version 4. Credentials are taken
+// from the access and secret key, a profile in the shared credentials file
+// or the environment, in this order. If a role is set, they are used to
+// assume it and the temporary credentials of the role sign the requests.
+type SigV4 struct {
+	region    string
+	accessKey string
+	secretKey string
+	profile   string
+	roleARN   string
+	service   string
+	next      http.RoundTripper
+
+	mtx         sync.Mutex
+	credentials *Credentials
+}
+
+func NewSigV4(region string, accessKey string, secretKey string, profile string, roleARN string, next http.RoundTripper) *SigV4 {
+	return &SigV4{
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		profile:   profile,
+		roleARN:   roleARN,
+		service:   SigV4Service,
+		next:      next,
+	}
+}
+
+func (s *SigV4) RoundTrip(req *http.Request) (*http.Response, error) {
+	credentials, err := s.currentCredentials(req)
+	if err != nil {
+		return nil, err
+	}
+
+	req = cloneRequest(req)
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	SignV4(req, body, credentials, s.region, s.service, time.Now())
+	return s.next.RoundTrip(req)
+}
+
+// currentCredentials returns the credentials to sign with, temporary
+// credentials are renewed before they expire
+func (s *SigV4) currentCredentials(req *http.Request) (*Credentials, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.credentials != nil && (s.credentials.Expires.IsZero() || time.Now().Add(time.Minute).Before(s.credentials.Expires)) {
+		return s.credentials, nil
+	}
+
+	credentials, err := s.baseCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if s.roleARN != "" {
+		credentials, err = s.assumeRole(req, credentials)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.credentials = credentials
+	return credentials, nil
+}
+
+func (s *SigV4) baseCredentials() (*Credentials, error) {
+	if s.accessKey != "" || s.secretKey != "" {
+		if s.accessKey == "" || s.secretKey == "" {
+			return nil, errors.New("sigv4 access_key and secret_key have to be set together")
+		}
+		return &Credentials{AccessKey: s.accessKey, SecretKey: s.secretKey}, nil
+	}
+
+	if s.profile != "" || os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		return profileCredentials(s.profile)
+	}
+	return &Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}, nil
+}
+
+// profileCredentials reads a profile from the shared credentials file,
+// ~/.aws/credentials unless AWS_SHARED_CREDENTIALS_FILE is set
+func profileCredentials(profile string) (*Credentials, error) {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".aws", "credentials")
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error reading aws credentials: %v", err))
+	}
+
+	credentials := &Credentials{}
+	section := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(strings.TrimPrefix(line[1:len(line)-1], "profile "))
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			credentials.AccessKey = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			credentials.SecretKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			credentials.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if credentials.AccessKey == "" || credentials.SecretKey == "" {
+		return nil, errors.New(fmt.Sprintf("no aws credentials found for profile %v in %v", profile, file))
+	}
+	return credentials, nil
+}
+
+// SignV4 adds the AWS Signature Version 4 headers to a request. All headers
+// that are set when it is signed are signed except the user agent, the
+// content length is signed as well.
+func SignV4(req *http.Request, body []byte, credentials *Credentials, region string, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	payloadHash := sha256Hex(body)
+
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	if req.ContentLength > 0 {
+		headers["content-length"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "user-agent" {
+			continue
+		}
+		var trimmed []string
+		for _, value := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := now.Format(sigV4DateFormat)
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%v Credential=%v/%v, SignedHeaders=%v, Signature=%v", sigV4Algorithm, credentials.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalPath encodes the already escaped path once more, all services
+// but S3 expect the path to be encoded twice
+func canonicalPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		segments = append(segments, uriEncode(segment))
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery sorts the query parameters by name and value
+func canonicalQuery(u *url.URL) string {
+	var pairs [][2]string
+	for key, values := range u.Query() {
+		for _, value := range values {
+			pairs = append(pairs, [2]string{uriEncode(key), uriEncode(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	var query []string
+	for _, pair := range pairs {
+		query = append(query, pair[0]+"="+pair[1])
+	}
+	return strings.Join(query, "&")
+}
+
+// uriEncode escapes everything but the unreserved characters of RFC 3986
+func uriEncode(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
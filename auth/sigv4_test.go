@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSignV4Reference compares signatures with those of the AWS SDK for Go
+// v2 for the same request
+func TestSignV4Reference(t *testing.T) {
+	for _, test := range []struct {
+		sessionToken string
+		expected     string
+	}{
+		{
+			expected: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/eu-west-1/aps/aws4_request, SignedHeaders=content-length;content-type;host;x-amz-content-sha256;x-amz-date;x-scope-orgid, Signature=6806f03f6090cb5d98c2d00cb4d581d97715e62068f50192272d5adeccad6e6a",
+		},
+		{
+			sessionToken: "session",
+			expected:     "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/eu-west-1/aps/aws4_request, SignedHeaders=content-length;content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token;x-scope-orgid, Signature=417aab2a708d957c499019644fcc7242f6acd30582bbd21e71856c81b3a7beb9",
+		},
+	} {
+		body := []byte("series")
+		req, err := http.NewRequest("POST", "http://localhost:9090/api/v1/write?b=2&a=1", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("X-Scope-OrgID", "team-a")
+		req.Header.Set("User-Agent", "write")
+		credentials := &Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", SessionToken: test.sessionToken}
+		SignV4(req, body, credentials, "eu-west-1", SigV4Service, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+		if got := req.Header.Get("Authorization"); got != test.expected {
+			t.Errorf("expected\n%v\ngot\n%v", test.expected, got)
+		}
+	}
+}
+
+// verify recomputes the signature of a received request from its signed
+// headers and body, it returns false for unknown keys and mismatches
+func verify(t *testing.T, req *http.Request, keys map[string]*Credentials, service string) bool {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorization := req.Header.Get("Authorization")
+	var accessKey, scope, signedHeaders string
+	_, err = fmt.Sscanf(strings.ReplaceAll(authorization, ",", ""), "AWS4-HMAC-SHA256 Credential=%s SignedHeaders=%s", &scope, &signedHeaders)
+	if err != nil {
+		t.Errorf("invalid authorization header %q: %v", authorization, err)
+		return false
+	}
+	accessKey, scope, _ = strings.Cut(scope, "/")
+	credentials, ok := keys[accessKey]
+	if !ok || !strings.HasSuffix(scope, "/eu-west-1/"+service+"/aws4_request") {
+		return false
+	}
+	if credentials.SessionToken != "" && req.Header.Get("X-Amz-Security-Token") != credentials.SessionToken {
+		return false
+	}
+
+	now, err := time.Parse(sigV4TimeFormat, req.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	signed, err := http.NewRequest(req.Method, "http://"+req.Host+req.RequestURI, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range strings.Split(signedHeaders, ";") {
+		if name != "host" && name != "content-length" {
+			signed.Header.Set(name, req.Header.Get(name))
+		}
+	}
+	SignV4(signed, body, credentials, "eu-west-1", service, now)
+	return signed.Header.Get("Authorization") == authorization
+}
+
+func TestSigV4RoundTrip(t *testing.T) {
+	base := &Credentials{AccessKey: "AKBASE", SecretKey: "base"}
+	role := &Credentials{AccessKey: "AKROLE", SecretKey: "role", SessionToken: "session"}
+
+	var assumed atomic.Int64
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !verify(t, req, map[string]*Credentials{base.AccessKey: base}, "sts") {
+			http.Error(w, "signature mismatch", http.StatusForbidden)
+			return
+		}
+		assumed.Add(1)
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>%v</AccessKeyId><SecretAccessKey>%v</SecretAccessKey><SessionToken>%v</SessionToken><Expiration>%v</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+			role.AccessKey, role.SecretKey, role.SessionToken, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer sts.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !verify(t, req, map[string]*Credentials{base.AccessKey: base, role.AccessKey: role}, SigV4Service) {
+			http.Error(w, "signature mismatch", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-Authorization", strings.Fields(req.Header.Get("Authorization"))[1])
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	write := func(rt http.RoundTripper) (int, string) {
+		req, err := http.NewRequest("POST", server.URL+"/api/v1/write?tenant=a%20b", bytes.NewReader([]byte("series")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get("X-Authorization")
+	}
+
+	status, credential := write(NewSigV4("eu-west-1", base.AccessKey, base.SecretKey, "", "", http.DefaultTransport))
+	if status != http.StatusNoContent || !strings.HasPrefix(credential, "Credential="+base.AccessKey+"/") {
+		t.Errorf("expected a request signed with the static keys, got %v %v", status, credential)
+	}
+
+	status, _ = write(NewSigV4("eu-west-1", base.AccessKey, "wrong", "", "", http.DefaultTransport))
+	if status != http.StatusForbidden {
+		t.Errorf("expected a wrong secret key to be rejected, got %v", status)
+	}
+
+	rt := NewSigV4("eu-west-1", base.AccessKey, base.SecretKey, "", "arn:aws:iam::123456789012:role/writer", http.DefaultTransport)
+	for i := 0; i < 2; i++ {
+		status, credential = write(rt)
+		if status != http.StatusNoContent || !strings.HasPrefix(credential, "Credential="+role.AccessKey+"/") {
+			t.Errorf("expected a request signed with the role credentials, got %v %v", status, credential)
+		}
+	}
+	if assumed.Load() != 1 {
+		t.Errorf("expected the role to be assumed once, got %v", assumed.Load())
+	}
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	_, err := NewSigV4("eu-west-1", base.AccessKey, "wrong", "", "arn:aws:iam::123456789012:role/writer", http.DefaultTransport).RoundTrip(req)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected the sts request to be rejected, got %v", err)
+	}
+}
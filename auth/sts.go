@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type assumeRoleResponse struct {
+	Credentials struct {
+		AccessKeyId     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"AssumeRoleResult>Credentials"`
+}
+
+// stsEndpoint returns the regional STS endpoint, AWS_ENDPOINT_URL_STS
+// overrides it like it does for the AWS SDKs
+func stsEndpoint(region string) string {
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL_STS"); endpoint != "" {
+		return endpoint
+	}
+	return fmt.Sprintf("https://sts.%v.amazonaws.com/", region)
+}
+
+// assumeRole exchanges credentials for the temporary credentials of the role
+func (s *SigV4) assumeRole(req *http.Request, credentials *Credentials) (*Credentials, error) {
+	form := url.Values{}
+	form.Set("Action", "AssumeRole")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", s.roleARN)
+	form.Set("RoleSessionName", fmt.Sprintf("write-%v", time.Now().Unix()))
+	body := []byte(form.Encode())
+
+	stsReq, err := http.NewRequestWithContext(req.Context(), "POST", stsEndpoint(s.region), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	stsReq.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	SignV4(stsReq, body, credentials, s.region, "sts", time.Now())
+
+	resp, err := s.next.RoundTrip(stsReq)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error assuming role %v: %v", s.roleARN, err))
+	}
+	defer resp.Body.Close()
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.New(fmt.Sprintf("unexpected sts status code: %v: %v", resp.StatusCode, strings.TrimSpace(string(content))))
+	}
+
+	role := assumeRoleResponse{}
+	err = xml.Unmarshal(content, &role)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error parsing sts response: %v", err))
+	}
+	if role.Credentials.AccessKeyId == "" {
+		return nil, errors.New("sts response contains no credentials")
+	}
+	return &Credentials{
+		AccessKey:    role.Credentials.AccessKeyId,
+		SecretKey:    role.Credentials.SecretAccessKey,
+		SessionToken: role.Credentials.SessionToken,
+		Expires:      role.Credentials.Expiration,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// NewTLSConfig creates the TLS settings of a client like the tls_config of
+// Prometheus. The client certificate is read for every handshake, so that
+// rotated certificates are picked up without a restart.
+func NewTLSConfig(caFile string, certFile string, keyFile string, serverName string, insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error reading ca file %v: %v", caFile, err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New(fmt.Sprintf("no certificates found in ca file %v", caFile))
+		}
+		config.RootCAs = pool
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("cert_file and key_file have to be set together")
+	}
+	if certFile != "" {
+		// fail early if the certificate can't be loaded
+		_, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error loading client certificate: %v", err))
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("error loading client certificate: %v", err))
+			}
+			return &cert, nil
+		}
+	}
+
+	return config, nil
+}
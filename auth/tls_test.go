@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+// issue creates a certificate signed by the parent, or a self-signed CA if
+// there is none
+func issue(t *testing.T, name string, parent *certificate) *certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &certificate{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+func (c *certificate) write(t *testing.T, certFile string, keyFile string) {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, c.pem)
+	writeFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := issue(t, "ca", nil)
+	serverCert := issue(t, "server", ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Authorization", req.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeFile(t, caFile, ca.pem)
+	issue(t, "client", ca).write(t, certFile, keyFile)
+
+	config, err := NewTLSConfig(caFile, certFile, keyFile, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every request is a new handshake
+	transport := &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}
+	if got := get(t, transport, server.URL); got != "client" {
+		t.Errorf("expected the client certificate, got %q", got)
+	}
+
+	// rotated certificates are used for the next handshake
+	issue(t, "rotated", ca).write(t, certFile, keyFile)
+	if got := get(t, transport, server.URL); got != "rotated" {
+		t.Errorf("expected the rotated client certificate, got %q", got)
+	}
+
+	// a certificate of another CA is rejected by the server
+	issue(t, "other", issue(t, "other ca", nil)).write(t, certFile, keyFile)
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	if resp, err := transport.RoundTrip(req); err == nil {
+		resp.Body.Close()
+		t.Error("expected the server to reject a certificate of another CA")
+	}
+
+	// the server certificate is verified against the CA
+	config, err = NewTLSConfig("", "", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	if resp, err := (&http.Transport{TLSClientConfig: config}).RoundTrip(req); err == nil {
+		resp.Body.Close()
+		t.Error("expected an error for an unknown server CA")
+	}
+
+	for _, files := range [][]string{{caFile, certFile, ""}, {filepath.Join(dir, "missing"), "", ""}, {keyFile, "", ""}} {
+		_, err := NewTLSConfig(files[0], files[1], files[2], "", false)
+		if err == nil {
+			t.Errorf("expected an error for ca file %v, cert file %q and key file %q", files[0], files[1], files[2])
+		}
+	}
+}
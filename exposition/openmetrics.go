@@ -0,0 +1,88 @@
+package exposition
+
+import (
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"write/progression"
+)
+
+// WriteOpenMetrics writes all samples of the write requests including their
+// timestamps in the OpenMetrics format, e.g. for backfilling them with
+// `promtool tsdb create-blocks-from openmetrics`. Staleness markers and
+// native histograms can't be represented and are skipped.
+func WriteOpenMetrics(w io.Writer, requests []prometheus.WriteRequest) error {
+	type history struct {
+		metadata *prometheus.MetricMetadata
+		series   []*prometheus.TimeSeries
+	}
+
+	// all samples of a family have to be written in one block
+	families := map[string]*history{}
+	for _, wr := range requests {
+		metadata := map[string]*prometheus.MetricMetadata{}
+		for _, m := range wr.Metadata {
+			metadata[m.MetricFamilyName] = m
+		}
+
+		for _, ts := range wr.Timeseries {
+			name := metricName(ts.Labels)
+			familyName := name
+			familyMetadata := metadata[name]
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if m, ok := metadata[strings.TrimSuffix(name, suffix)]; ok && familyMetadata == nil {
+					familyName = strings.TrimSuffix(name, suffix)
+					familyMetadata = m
+				}
+			}
+
+			h, ok := families[familyName]
+			if !ok {
+				h = &history{
+					metadata: familyMetadata,
+				}
+				families[familyName] = h
+			}
+			h.series = append(h.series, ts)
+		}
+	}
+
+	var names []string
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		h := families[name]
+		familyName, err := writeHeader(w, name, h.metadata, true)
+		if err != nil {
+			return err
+		}
+
+		for _, ts := range h.series {
+			samples := append([]*prometheus.Sample{}, ts.Samples...)
+			sort.SliceStable(samples, func(i, j int) bool {
+				return samples[i].Timestamp < samples[j].Timestamp
+			})
+
+			sample := sampleName(ts.Labels, familyName, h.metadata, true) + formatLabels(ts.Labels)
+			for _, s := range samples {
+				if math.Float64bits(s.Value) == math.Float64bits(progression.StaleNaN) {
+					continue
+				}
+				_, err = fmt.Fprintf(w, "%v %v %v\n", sample, formatFloat(s.Value), strconv.FormatFloat(float64(s.Timestamp)/1000, 'f', -1, 64))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	_, err := fmt.Fprint(w, "# EOF\n")
+	return err
+}
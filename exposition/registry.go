@@ -0,0 +1,344 @@
+package exposition
+
+import (
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"write/progression"
+)
+
+const (
+	MetricsPath = "/metrics"
+
+	ContentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// entry is the latest state of a series
+type entry struct {
+	labels   []*prometheus.Label
+	value    float64
+	exemplar *prometheus.Exemplar
+}
+
+// Registry keeps the latest value of every series and exposes them to be
+// scraped in the Prometheus text or OpenMetrics format
+type Registry struct {
+	lock       sync.Mutex
+	series     map[string]*entry
+	metadata   map[string]*prometheus.MetricMetadata
+	collectors []Collector
+}
+
+// Collector returns the current samples of series that are only updated
+// when they are scraped
+type Collector func() *prometheus.WriteRequest
+
+func NewRegistry() *Registry {
+	return &Registry{
+		series:   map[string]*entry{},
+		metadata: map[string]*prometheus.MetricMetadata{},
+	}
+}
+
+// Register adds a collector that is called on every scrape
+func (r *Registry) Register(collector Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.collectors = append(r.collectors, collector)
+}
+
+// Update stores the latest samples of a write request. Series receiving a
+// staleness marker are removed, native histograms are not supported by the
+// text formats and ignored.
+func (r *Registry) Update(wr *prometheus.WriteRequest) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, metadata := range wr.Metadata {
+		r.metadata[metadata.MetricFamilyName] = metadata
+	}
+
+	for _, ts := range wr.Timeseries {
+		if len(ts.Samples) == 0 {
+			continue
+		}
+		key := seriesKey(ts.Labels)
+		sample := ts.Samples[len(ts.Samples)-1]
+		if math.Float64bits(sample.Value) == math.Float64bits(progression.StaleNaN) {
+			// a staleness marker, the series disappears from the next scrape
+			delete(r.series, key)
+			continue
+		}
+
+		current, ok := r.series[key]
+		if !ok {
+			current = &entry{
+				labels: ts.Labels,
+			}
+			r.series[key] = current
+		}
+		current.value = sample.Value
+		if len(ts.Exemplars) > 0 {
+			current.exemplar = ts.Exemplars[len(ts.Exemplars)-1]
+		}
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", ContentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", ContentTypeText)
+	}
+	r.Write(w, openMetrics)
+}
+
+// family groups the series of a metric family
+type family struct {
+	name     string
+	metadata *prometheus.MetricMetadata
+	series   []*entry
+}
+
+// Write writes all series grouped by metric family
+func (r *Registry) Write(w io.Writer, openMetrics bool) error {
+	r.lock.Lock()
+	collectors := r.collectors
+	r.lock.Unlock()
+	for _, collector := range collectors {
+		r.Update(collector())
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	families := map[string]*family{}
+	for _, current := range r.series {
+		name := metricName(current.labels)
+		familyName, metadata := r.findMetadata(name)
+		f, ok := families[familyName]
+		if !ok {
+			f = &family{
+				name:     familyName,
+				metadata: metadata,
+			}
+			families[familyName] = f
+		}
+		f.series = append(f.series, current)
+	}
+
+	var names []string
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := writeFamily(w, families[name], openMetrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	if openMetrics {
+		_, err := fmt.Fprint(w, "# EOF\n")
+		return err
+	}
+	return nil
+}
+
+// findMetadata returns the family name and metadata of a series, the caller
+// must hold the lock
+func (r *Registry) findMetadata(name string) (string, *prometheus.MetricMetadata) {
+	if metadata, ok := r.metadata[name]; ok {
+		return name, metadata
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		familyName := strings.TrimSuffix(name, suffix)
+		if metadata, ok := r.metadata[familyName]; ok && familyName != name {
+			return familyName, metadata
+		}
+	}
+	return name, nil
+}
+
+func metricType(metadata *prometheus.MetricMetadata, openMetrics bool) string {
+	if metadata != nil {
+		switch metadata.Type {
+		case prometheus.MetricMetadata_COUNTER:
+			return "counter"
+		case prometheus.MetricMetadata_GAUGE:
+			return "gauge"
+		case prometheus.MetricMetadata_HISTOGRAM:
+			return "histogram"
+		case prometheus.MetricMetadata_SUMMARY:
+			return "summary"
+		}
+	}
+	if openMetrics {
+		return "unknown"
+	}
+	return "untyped"
+}
+
+// writeHeader writes the HELP and TYPE lines of a family and returns the
+// name of the family
+func writeHeader(w io.Writer, name string, metadata *prometheus.MetricMetadata, openMetrics bool) (string, error) {
+	familyType := metricType(metadata, openMetrics)
+	if openMetrics && familyType == "counter" {
+		// OpenMetrics counter families don't carry the _total suffix
+		name = strings.TrimSuffix(name, "_total")
+	}
+
+	if metadata != nil && metadata.Help != "" {
+		_, err := fmt.Fprintf(w, "# HELP %v %v\n", name, escape(metadata.Help, false))
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err := fmt.Fprintf(w, "# TYPE %v %v\n", name, familyType)
+	return name, err
+}
+
+// sampleName returns the name of a sample of a family
+func sampleName(labels []*prometheus.Label, familyName string, metadata *prometheus.MetricMetadata, openMetrics bool) string {
+	if openMetrics && metricType(metadata, openMetrics) == "counter" {
+		return familyName + "_total"
+	}
+	return metricName(labels)
+}
+
+func writeFamily(w io.Writer, f *family, openMetrics bool) error {
+	sort.Slice(f.series, func(i, j int) bool {
+		return seriesLess(f.series[i].labels, f.series[j].labels)
+	})
+
+	familyName, err := writeHeader(w, f.name, f.metadata, openMetrics)
+	if err != nil {
+		return err
+	}
+
+	for _, current := range f.series {
+		name := sampleName(current.labels, familyName, f.metadata, openMetrics)
+		line := name + formatLabels(current.labels) + " " + formatFloat(current.value)
+		if openMetrics && current.exemplar != nil && len(current.exemplar.Labels) > 0 {
+			line += " # " + formatLabels(current.exemplar.Labels) + " " + formatFloat(current.exemplar.Value) +
+				" " + strconv.FormatFloat(float64(current.exemplar.Timestamp)/1000, 'f', -1, 64)
+		}
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func metricName(labels []*prometheus.Label) string {
+	for _, label := range labels {
+		if label.Name == "__name__" {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+// formatLabels formats all labels except the metric name
+func formatLabels(labels []*prometheus.Label) string {
+	var pairs []string
+	for _, label := range labels {
+		if label.Name == "__name__" {
+			continue
+		}
+		pairs = append(pairs, label.Name+"=\""+escape(label.Value, true)+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(value string, quotes bool) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\n", "\\n")
+	if quotes {
+		value = strings.ReplaceAll(value, "\"", "\\\"")
+	}
+	return value
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// seriesLess orders the series of a family so that the buckets and quantiles
+// of a histogram or summary are next to each other, followed by their
+// _count and _sum, with the buckets and quantiles in ascending numeric order
+// and +Inf last
+func seriesLess(a []*prometheus.Label, b []*prometheus.Label) bool {
+	groupA, groupB := groupKey(a), groupKey(b)
+	if groupA != groupB {
+		return groupA < groupB
+	}
+	nameA, nameB := metricName(a), metricName(b)
+	if nameA != nameB {
+		return nameA < nameB
+	}
+	for _, name := range []string{"le", "quantile"} {
+		valueA, okA := labelValue(a, name)
+		valueB, okB := labelValue(b, name)
+		if okA && okB && valueA != valueB {
+			boundA, errA := strconv.ParseFloat(valueA, 64)
+			boundB, errB := strconv.ParseFloat(valueB, 64)
+			if errA == nil && errB == nil {
+				return boundA < boundB
+			}
+			return valueA < valueB
+		}
+	}
+	return seriesKey(a) < seriesKey(b)
+}
+
+// groupKey identifies the series of one histogram or summary
+func groupKey(labels []*prometheus.Label) string {
+	var rest []*prometheus.Label
+	for _, label := range labels {
+		if label.Name != "__name__" && label.Name != "le" && label.Name != "quantile" {
+			rest = append(rest, label)
+		}
+	}
+	return seriesKey(rest)
+}
+
+func labelValue(labels []*prometheus.Label, name string) (string, bool) {
+	for _, label := range labels {
+		if label.Name == name {
+			return label.Value, true
+		}
+	}
+	return "", false
+}
+
+func seriesKey(labels []*prometheus.Label) string {
+	var pairs []string
+	for _, label := range labels {
+		pairs = append(pairs, label.Name+"=\""+label.Value+"\"")
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
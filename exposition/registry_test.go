@@ -0,0 +1,99 @@
+package exposition
+
+import (
+	"bytes"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"strings"
+	"testing"
+	"write/sink"
+)
+
+func TestHistogramOrder(t *testing.T) {
+	wr := &prometheus.WriteRequest{
+		Metadata: []*prometheus.MetricMetadata{
+			{MetricFamilyName: "latency", Type: prometheus.MetricMetadata_HISTOGRAM},
+		},
+	}
+	series := func(name string, le string, job string, value float64) *prometheus.TimeSeries {
+		labels := []*prometheus.Label{{Name: "__name__", Value: name}, {Name: "job", Value: job}}
+		if le != "" {
+			labels = append(labels, &prometheus.Label{Name: "le", Value: le})
+		}
+		return &prometheus.TimeSeries{
+			Labels:  labels,
+			Samples: []*prometheus.Sample{{Value: value, Timestamp: 1000}},
+		}
+	}
+	for _, job := range []string{"b", "a"} {
+		wr.Timeseries = append(wr.Timeseries,
+			series("latency_sum", "", job, 9),
+			series("latency_bucket", "+Inf", job, 4),
+			series("latency_bucket", "10", job, 3),
+			series("latency_bucket", "2.5", job, 2),
+			series("latency_bucket", "0.1", job, 1),
+			series("latency_count", "", job, 4),
+		)
+	}
+
+	registry := NewRegistry()
+	registry.Update(wr)
+	var out bytes.Buffer
+	err := registry.Write(&out, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "latency") {
+			got = append(got, strings.Fields(line)[0])
+		}
+	}
+	var expected []string
+	for _, job := range []string{"a", "b"} {
+		for _, le := range []string{"0.1", "2.5", "10", "+Inf"} {
+			expected = append(expected, `latency_bucket{job="`+job+`",le="`+le+`"}`)
+		}
+		expected = append(expected, `latency_count{job="`+job+`"}`, `latency_sum{job="`+job+`"}`)
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%v\ngot\n%v", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestRetryStatsCollector(t *testing.T) {
+	stats := &sink.RetryStats{}
+	registry := NewRegistry()
+	registry.Register(func() *prometheus.WriteRequest {
+		return stats.WriteRequest(1000)
+	})
+
+	stats.RetriedRequests.Add(2)
+	stats.RetriedSamples.Add(6)
+	stats.DroppedSamples.Add(3)
+	var out bytes.Buffer
+	err := registry.Write(&out, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE write_samples_retried_total counter\nwrite_samples_retried_total 6\n",
+		"# TYPE write_samples_dropped_total counter\nwrite_samples_dropped_total 3\n",
+		"# TYPE write_requests_retried_total counter\nwrite_requests_retried_total 2\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %q in\n%v", line, out.String())
+		}
+	}
+
+	// the counters are read again on every scrape
+	stats.DroppedSamples.Add(1)
+	out.Reset()
+	err = registry.Write(&out, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "write_samples_dropped_total 4\n") {
+		t.Errorf("expected the updated count in\n%v", out.String())
+	}
+}
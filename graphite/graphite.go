@@ -0,0 +1,160 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FormatTagged writes labels as Graphite tags, name;label=value
+	FormatTagged = "tagged"
+	// FormatPath appends the label values to the metric name, name.value,
+	// in the order of the label names
+	FormatPath = "path"
+)
+
+// characters with a meaning in the plaintext protocol are replaced
+var (
+	tagEscaper  = strings.NewReplacer(";", "_", "~", "_", " ", "_", "\n", "_")
+	pathEscaper = strings.NewReplacer(".", "_", ";", "_", " ", "_", "\n", "_")
+)
+
+// IsValidFormat returns true if the format is one of the supported ways to
+// write labels
+func IsValidFormat(format string) bool {
+	return format == FormatTagged || format == FormatPath
+}
+
+func metricPath(labels []*prometheus.Label, format string) string {
+	name := ""
+	var rest []*prometheus.Label
+	for _, label := range labels {
+		if label.Name == "__name__" {
+			name = label.Value
+		} else if label.Value != "" {
+			rest = append(rest, label)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return rest[i].Name < rest[j].Name
+	})
+
+	if format == FormatPath {
+		parts := []string{pathEscaper.Replace(name)}
+		for _, label := range rest {
+			parts = append(parts, pathEscaper.Replace(label.Value))
+		}
+		return strings.Join(parts, ".")
+	}
+
+	parts := []string{tagEscaper.Replace(name)}
+	for _, label := range rest {
+		parts = append(parts, tagEscaper.Replace(label.Name)+"="+tagEscaper.Replace(label.Value))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Lines renders the samples of a remote write request in the plaintext
+// protocol with timestamps in seconds. NaN values, staleness markers and
+// native histograms are skipped.
+func Lines(wr *prometheus.WriteRequest, format string) []byte {
+	var b bytes.Buffer
+	for _, ts := range wr.Timeseries {
+		path := metricPath(ts.Labels, format)
+		for _, sample := range ts.Samples {
+			if math.IsNaN(sample.Value) {
+				continue
+			}
+			b.WriteString(path)
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatInt(sample.Timestamp/1000, 10))
+			b.WriteString("\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// Client writes to a Graphite plaintext listener, e.g. localhost:2003. It
+// keeps its connection open between writes and redials once it fails.
+type Client struct {
+	address string
+	format  string
+	mutex   sync.Mutex
+	conn    net.Conn
+}
+
+func NewClient(address string, format string) *Client {
+	return &Client{
+		address: address,
+		format:  format,
+	}
+}
+
+// Send writes the samples of a request, a failed write closes the
+// connection
+func (c *Client) Send(ctx context.Context, wr *prometheus.WriteRequest) error {
+	lines := Lines(wr, c.format)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil && closed(c.conn) {
+		c.conn.Close()
+		c.conn = nil
+	}
+	if c.conn == nil {
+		dialer := net.Dialer{
+			Timeout: 30 * time.Second,
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", c.address)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := c.conn.Write(lines)
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return err
+}
+
+// closed returns true if the listener closed the connection. Graphite never
+// sends anything, so a read only returns before its deadline when the
+// connection is gone; a write would succeed and lose the lines. The read
+// deadline is a millisecond ahead rather than in the past: with a past
+// deadline the read times out without looking at the connection and a
+// closed connection is never noticed.
+func closed(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	var netErr net.Error
+	return !(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
@@ -0,0 +1,72 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"net"
+	"testing"
+)
+
+func request(value float64) *prometheus.WriteRequest {
+	return &prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			{
+				Labels:  []*prometheus.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+				Samples: []*prometheus.Sample{{Value: value, Timestamp: 1000}},
+			},
+		},
+	}
+}
+
+func TestClientReusesConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	type line struct {
+		conn int
+		text string
+	}
+	lines := make(chan line)
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+			go func(i int) {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- line{conn: i, text: scanner.Text()}
+				}
+			}(i)
+		}
+	}()
+
+	client := NewClient(listener.Addr().String(), FormatTagged)
+	defer client.Close()
+	send := func(value float64, expected line) {
+		err := client.Send(context.Background(), request(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := <-lines
+		if got != expected {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	}
+
+	send(1, line{conn: 0, text: "up;job=api 1 1"})
+	first := <-accepted
+	send(2, line{conn: 0, text: "up;job=api 2 1"})
+
+	// the listener closes the connection, the client redials
+	first.Close()
+	send(3, line{conn: 1, text: "up;job=api 3 1"})
+	<-accepted
+}
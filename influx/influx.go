@@ -0,0 +1,62 @@
+package influx
+
+import (
+	"bytes"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldName is the field holding the sample value, like Telegraf does for
+// Prometheus metrics
+const FieldName = "value"
+
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	tagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+// Lines renders the samples of a remote write request as line protocol.
+// The metric name is the measurement and all other labels are tags.
+// Influx can't store NaN and infinite values, so these and staleness
+// markers are skipped, as are native histograms.
+func Lines(wr *prometheus.WriteRequest) []byte {
+	var b bytes.Buffer
+	for _, ts := range wr.Timeseries {
+		name := ""
+		var tags []string
+		for _, label := range ts.Labels {
+			if label.Name == "__name__" {
+				name = label.Value
+				continue
+			}
+			if label.Value == "" {
+				continue
+			}
+			tags = append(tags, tagEscaper.Replace(label.Name)+"="+tagEscaper.Replace(label.Value))
+		}
+		sort.Strings(tags)
+
+		series := measurementEscaper.Replace(name)
+		if len(tags) > 0 {
+			series += "," + strings.Join(tags, ",")
+		}
+
+		for _, sample := range ts.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			b.WriteString(series)
+			b.WriteString(" " + FieldName + "=")
+			b.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+			b.WriteString(" ")
+			// the default precision of the write endpoints is nanoseconds
+			b.WriteString(strconv.FormatInt(sample.Timestamp*int64(time.Millisecond), 10))
+			b.WriteString("\n")
+		}
+	}
+	return b.Bytes()
+}
@@ -1,135 +1,992 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/Shopify/go-lua"
 	"github.com/ghodss/yaml"
-	"github.com/golang/protobuf/proto"
-	"github.com/golang/snappy"
 	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
-	"sync"
+	"regexp"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
+	"write/auth"
+	"write/exposition"
+	"write/graphite"
 	"write/ingest"
+	"write/observation"
+	"write/otlp"
 	"write/progression"
+	"write/receiver"
+	"write/relabel"
+	"write/remotewrite"
+	"write/selector"
+	"write/sink"
+	"write/tsdb"
 )
 
 const (
 	DefaultConfigFile = "./config.yml"
 )
 
+const (
+	OutputRemoteWrite = "remote-write"
+	OutputOpenMetrics = "openmetrics"
+	OutputTSDB        = "tsdb"
+)
+
+const (
+	SinkRemoteWrite = "remote_write"
+	SinkOTLP        = "otlp"
+	SinkInflux      = "influx"
+	SinkGraphite    = "graphite"
+)
+
+const (
+	SeriesTypeGauge   = "gauge"
+	SeriesTypeCounter = "counter"
+)
+
+type ConfigDistribution struct {
+	Type     string  `json:"type"`
+	Mean     float64 `json:"mean"`
+	StdDev   float64 `json:"stddev"`
+	Function string  `json:"function"`
+	Seed     int64   `json:"seed"`
+}
+
+type ConfigHistogram struct {
+	Buckets      []float64          `json:"buckets"`
+	Distribution ConfigDistribution `json:"distribution"`
+}
+
+type ConfigNativeHistogram struct {
+	Schema        int32              `json:"schema"`
+	ZeroThreshold float64            `json:"zero_threshold"`
+	Distribution  ConfigDistribution `json:"distribution"`
+}
+
+type ConfigSummary struct {
+	Quantiles    []float64          `json:"quantiles"`
+	Window       int                `json:"window"`
+	Distribution ConfigDistribution `json:"distribution"`
+}
+
+type ConfigExemplars struct {
+	Every  int               `json:"every"`
+	Labels map[string]string `json:"labels"`
+	Value  string            `json:"value"`
+	Seed   int64             `json:"seed"`
+}
+
 type ConfigTimeseries struct {
-	Series      string `json:"series"`
-	Progression string `json:"progression"`
-	Realtime    string `json:"realtime"`
+	Series          string                 `json:"series"`
+	Type            string                 `json:"type"`
+	Histogram       *ConfigHistogram       `json:"histogram"`
+	NativeHistogram *ConfigNativeHistogram `json:"native_histogram"`
+	Summary         *ConfigSummary         `json:"summary"`
+	Exemplars       *ConfigExemplars       `json:"exemplars"`
+	Progression     string                 `json:"progression"`
+	Realtime        string                 `json:"realtime"`
+	Tenant          string                 `json:"tenant"`
+}
+
+type ConfigBasicAuth struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password_file"`
+}
+
+type ConfigAuthorization struct {
+	Type            string `json:"type"`
+	Credentials     string `json:"credentials"`
+	CredentialsFile string `json:"credentials_file"`
+}
+
+type ConfigOAuth2 struct {
+	ClientID         string            `json:"client_id"`
+	ClientSecret     string            `json:"client_secret"`
+	ClientSecretFile string            `json:"client_secret_file"`
+	TokenURL         string            `json:"token_url"`
+	Scopes           []string          `json:"scopes"`
+	EndpointParams   map[string]string `json:"endpoint_params"`
+}
+
+type ConfigSigV4 struct {
+	Region    string `json:"region"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	Profile   string `json:"profile"`
+	RoleARN   string `json:"role_arn"`
+}
+
+type ConfigTLS struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// ConfigHTTPClient is the shape of the http client settings in Prometheus
+type ConfigHTTPClient struct {
+	BasicAuth     *ConfigBasicAuth     `json:"basic_auth"`
+	Authorization *ConfigAuthorization `json:"authorization"`
+	OAuth2        *ConfigOAuth2        `json:"oauth2"`
+	SigV4         *ConfigSigV4         `json:"sigv4"`
+	TLSConfig     *ConfigTLS           `json:"tls_config"`
+	Headers       map[string]string    `json:"headers"`
+}
+
+// ConfigRelabel is a relabeling rule in the shape of the relabel_configs of
+// Prometheus
+type ConfigRelabel struct {
+	SourceLabels []string `json:"source_labels"`
+	Separator    *string  `json:"separator"`
+	TargetLabel  string   `json:"target_label"`
+	Regex        *string  `json:"regex"`
+	Modulus      uint64   `json:"modulus"`
+	Replacement  *string  `json:"replacement"`
+	Action       string   `json:"action"`
+}
+
+type ConfigSink struct {
+	ConfigHTTPClient
+	Type                string          `json:"type"`
+	Url                 string          `json:"url"`
+	Address             string          `json:"address"`
+	Protocol            string          `json:"protocol"`
+	Format              string          `json:"format"`
+	Rejections          string          `json:"rejections"`
+	ResourceLabels      []string        `json:"resource_labels"`
+	Series              []string        `json:"series"`
+	WriteRelabelConfigs []ConfigRelabel `json:"write_relabel_configs"`
+}
+
+// ConfigRemoteWrite is a remote write target in the shape of the
+// remote_write section of Prometheus
+type ConfigRemoteWrite struct {
+	ConfigHTTPClient
+	Url                 string          `json:"url"`
+	Protocol            string          `json:"protocol"`
+	Rejections          string          `json:"rejections"`
+	Series              []string        `json:"series"`
+	WriteRelabelConfigs []ConfigRelabel `json:"write_relabel_configs"`
 }
 
 type ConfigRoot struct {
-	Interval string             `json:"interval"`
-	Series   []ConfigTimeseries `json:"time_series"`
+	Interval       string              `json:"interval"`
+	Tenant         string              `json:"tenant"`
+	ExternalLabels map[string]string   `json:"external_labels"`
+	RemoteWrite    []ConfigRemoteWrite `json:"remote_write"`
+	Sinks          []ConfigSink        `json:"sinks"`
+	// WriteRelabelConfigs are applied to the labels of all series
+	WriteRelabelConfigs []ConfigRelabel    `json:"write_relabel_configs"`
+	Series              []ConfigTimeseries `json:"time_series"`
 }
 
 type RealtimeContext struct {
-	rt progression.ProgressionProvider
-	ts *prometheus.TimeSeries
+	rt          progression.ProgressionProvider
+	series      []*prometheus.TimeSeries
+	metadata    *prometheus.MetricMetadata
+	aggregation observation.Aggregation
+	exemplars   *observation.Exemplars
+	created     int64
+	tenant      string
 }
 
-func sendRequest(wr *prometheus.WriteRequest, url *url.URL) error {
-	data, _ := proto.Marshal(wr)
-	encoded := snappy.Encode(nil, data)
+// appendValue appends the samples derived from the value of a progression to
+// the series. Without an aggregation the value is used as it is, otherwise it
+// is the number of observations made. Infinite values can't be a number of
+// observations, the aggregation keeps its state for them.
+func appendValue(series []*prometheus.TimeSeries, aggregation observation.Aggregation, exemplars *observation.Exemplars, value float64, timestamp int64) error {
+	if aggregation == nil || math.IsNaN(value) {
+		// staleness markers apply to all derived series
+		for _, ts := range series {
+			ts.Samples = append(ts.Samples, &prometheus.Sample{
+				Value:     value,
+				Timestamp: timestamp,
+			})
+		}
 
-	body := bytes.NewReader(encoded)
-	req, err := http.NewRequest("POST", url.String(), body)
-	if err != nil {
+		if exemplars != nil && aggregation == nil && !math.IsNaN(value) {
+			if exemplar := exemplars.Next(value, timestamp); exemplar != nil {
+				series[0].Exemplars = append(series[0].Exemplars, exemplar)
+			}
+		}
+		return nil
+	}
+
+	if err := aggregation.Observe(value); err != nil {
 		return err
 	}
+	aggregation.Append(series, timestamp)
 
-	req.Header.Set("Content-Type", "application/x-protobuf")
-	req.Header.Set("Content-Encoding", "snappy")
-	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if exemplified, ok := aggregation.(observation.Exemplified); ok && exemplars != nil {
+		// exemplars of histograms carry the value of an observation
+		if index, observed, ok := exemplified.LastObservation(); ok {
+			if exemplar := exemplars.Next(observed, timestamp); exemplar != nil {
+				series[index].Exemplars = append(series[index].Exemplars, exemplar)
+			}
+		}
+	}
+	return nil
+}
 
-	httpClient := http.Client{
-		Timeout: 30 * time.Second,
+func newExemplars(ts ConfigTimeseries, luaState *lua.State) (*observation.Exemplars, error) {
+	if ts.Exemplars == nil {
+		return nil, nil
+	}
+	if ts.Summary != nil {
+		// Prometheus doesn't store exemplars of summaries
+		return nil, errors.New(fmt.Sprintf("exemplars are not supported for summaries: %v", ts.Series))
 	}
 
-	resp, err := httpClient.Do(req.WithContext(context.TODO()))
-	if err != nil {
-		return err
+	seed := ts.Exemplars.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return observation.NewExemplars(ts.Exemplars.Every, ts.Exemplars.Labels, ts.Exemplars.Value, seed, luaState)
+}
+
+func newDistribution(config ConfigDistribution, luaState *lua.State) (observation.Distribution, error) {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return observation.NewDistribution(config.Type, config.Mean, config.StdDev, config.Function, seed, luaState)
+}
+
+func newAggregation(ts ConfigTimeseries, luaState *lua.State) (observation.Aggregation, error) {
+	configured := 0
+	for _, aggregation := range []bool{ts.Histogram != nil, ts.NativeHistogram != nil, ts.Summary != nil} {
+		if aggregation {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return nil, errors.New("histogram, native_histogram and summary are mutually exclusive")
 	}
 
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		if resp.StatusCode == 400 {
-			// possibly duplicate data? ignore it.
-			log.Println("invalid data detected, ignoring it")
-			return nil
+	if ts.Histogram != nil {
+		distribution, err := newDistribution(ts.Histogram.Distribution, luaState)
+		if err != nil {
+			return nil, err
 		}
+		return observation.NewClassicHistogram(ts.Histogram.Buckets, distribution)
+	}
 
-		return errors.New(fmt.Sprintf("unexpected remote write status code: %v", resp.StatusCode))
+	if ts.NativeHistogram != nil {
+		distribution, err := newDistribution(ts.NativeHistogram.Distribution, luaState)
+		if err != nil {
+			return nil, err
+		}
+		return observation.NewNativeHistogram(ts.NativeHistogram.Schema, ts.NativeHistogram.ZeroThreshold, distribution)
 	}
 
-	return nil
+	if ts.Summary != nil {
+		distribution, err := newDistribution(ts.Summary.Distribution, luaState)
+		if err != nil {
+			return nil, err
+		}
+		return observation.NewSummary(ts.Summary.Quantiles, ts.Summary.Window, distribution)
+	}
+
+	return nil, nil
+}
+
+func metricName(labels []*prometheus.Label) string {
+	for _, label := range labels {
+		if label.Name == "__name__" {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+func metricMetadata(ts ConfigTimeseries, labels []*prometheus.Label) (*prometheus.MetricMetadata, error) {
+	metadata := &prometheus.MetricMetadata{
+		MetricFamilyName: metricName(labels),
+	}
+
+	if ts.Histogram != nil || ts.NativeHistogram != nil {
+		metadata.Type = prometheus.MetricMetadata_HISTOGRAM
+		return metadata, nil
+	}
+
+	if ts.Summary != nil {
+		metadata.Type = prometheus.MetricMetadata_SUMMARY
+		return metadata, nil
+	}
+
+	switch ts.Type {
+	case "", SeriesTypeGauge:
+		metadata.Type = prometheus.MetricMetadata_GAUGE
+	case SeriesTypeCounter:
+		metadata.Type = prometheus.MetricMetadata_COUNTER
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown series type: %v", ts.Type))
+	}
+
+	return metadata, nil
+}
+
+// firstTimestamp returns the earliest timestamp of all samples in the request
+func firstTimestamp(wr *prometheus.WriteRequest) int64 {
+	var first int64 = 0
+	for _, ts := range wr.Timeseries {
+		for _, sample := range ts.Samples {
+			if first == 0 || sample.Timestamp < first {
+				first = sample.Timestamp
+			}
+		}
+		for _, histogram := range ts.Histograms {
+			if first == 0 || histogram.Timestamp < first {
+				first = histogram.Timestamp
+			}
+		}
+	}
+	return first
 }
 
 var (
 	prometheusUrl *string
 	configFile    *string
 	functionsFile *string
+	staleOnStop   *bool
+
+	maxSamplesPerSend *int
+	shards            *int
+
+	retryMinBackoff  *time.Duration
+	retryMaxBackoff  *time.Duration
+	retryMaxDuration *time.Duration
+	scrapeAddress    *string
+	metricsAddress   *string
+	output           *string
+	blockDuration    *time.Duration
+
+	remoteWriteProtocol *string
+	rejectionPolicy     *string
+
+	basicAuthUsername     *string
+	basicAuthPassword     *string
+	basicAuthPasswordFile *string
+	bearerToken           *string
+	bearerTokenFile       *string
+	remoteWriteHeaders    = headerFlag{}
+
+	externalLabels = labelFlag{}
+
+	tlsCAFile             *string
+	tlsCertFile           *string
+	tlsKeyFile            *string
+	tlsServerName         *string
+	tlsInsecureSkipVerify *bool
+
+	sigV4Region    *string
+	sigV4AccessKey *string
+	sigV4SecretKey *string
+	sigV4Profile   *string
+	sigV4RoleARN   *string
+
+	otlpUrl            *string
+	otlpProtocol       *string
+	otlpResourceLabels *string
+
+	influxUrl       *string
+	graphiteAddress *string
+	graphiteFormat  *string
 )
 
 func init() {
-	prometheusUrl = flag.String("prometheus.url", "", "prometheus http url")
+	prometheusUrl = flag.String("prometheus.url", "", "remote write url, /api/v1/write is added to urls without a path")
 	configFile = flag.String("config.file", DefaultConfigFile, "config file location")
 	functionsFile = flag.String("scripting.file", "", "location of functions for scripting")
+	remoteWriteProtocol = flag.String("remote-write.protocol", remotewrite.ProtocolV1, fmt.Sprintf("remote write protobuf message, %v or %v", remotewrite.ProtocolV1, remotewrite.ProtocolV2))
+	rejectionPolicy = flag.String("remote-write.rejections", remotewrite.DefaultPolicy, "what to do about samples rejected with 400 by reason, comma separated reason=ignore|warn|fail pairs")
+	basicAuthUsername = flag.String("remote-write.basic-auth.username", "", "username for basic auth")
+	basicAuthPassword = flag.String("remote-write.basic-auth.password", "", "password for basic auth")
+	basicAuthPasswordFile = flag.String("remote-write.basic-auth.password-file", "", "file to read the password for basic auth from")
+	bearerToken = flag.String("remote-write.bearer-token", "", "bearer token")
+	bearerTokenFile = flag.String("remote-write.bearer-token-file", "", "file to read the bearer token from, it is read for every request")
+	flag.Var(externalLabels, "external-label", "label added to every series as name=value, can be repeated, overrides external_labels of the config file")
+	flag.Var(remoteWriteHeaders, "remote-write.header", "header sent with every request as Name: value, can be repeated")
+	tlsCAFile = flag.String("remote-write.tls.ca-file", "", "file with the CA certificates to verify the server with")
+	tlsCertFile = flag.String("remote-write.tls.cert-file", "", "file with the client certificate for mutual TLS")
+	tlsKeyFile = flag.String("remote-write.tls.key-file", "", "file with the key of the client certificate")
+	tlsServerName = flag.String("remote-write.tls.server-name", "", "server name to verify the certificate of the server against")
+	tlsInsecureSkipVerify = flag.Bool("remote-write.tls.insecure-skip-verify", false, "don't verify the certificate of the server")
+	sigV4Region = flag.String("remote-write.sigv4.region", "", "AWS region to sign requests for with SigV4")
+	sigV4AccessKey = flag.String("remote-write.sigv4.access-key", "", "AWS access key for SigV4, the environment and shared credentials file are used if unset")
+	sigV4SecretKey = flag.String("remote-write.sigv4.secret-key", "", "AWS secret key for SigV4")
+	sigV4Profile = flag.String("remote-write.sigv4.profile", "", "profile in the AWS shared credentials file for SigV4")
+	sigV4RoleARN = flag.String("remote-write.sigv4.role-arn", "", "AWS role to assume for SigV4")
+	output = flag.String("output", OutputRemoteWrite, "where to write precalculated series to, remote-write, openmetrics:<file> or tsdb:<directory>")
+	blockDuration = flag.Duration("tsdb.block-duration", 2*time.Hour, "time range of the blocks written with the tsdb output")
+	scrapeAddress = flag.String("scrape.address", "", "serve realtime series on this address to be scraped instead of sending them")
+	metricsAddress = flag.String("metrics.address", "", "serve metrics of the tool itself, e.g. retried and dropped samples, on this address")
+	staleOnStop = flag.Bool("realtime.stale-on-stop", false, "end realtime series with a staleness marker when stopped")
+	maxSamplesPerSend = flag.Int("realtime.max-samples-per-send", 2000, "maximum number of samples per request in realtime mode")
+	shards = flag.Int("realtime.shards", 4, "number of concurrent requests in realtime mode")
+	retryMinBackoff = flag.Duration("retry.min-backoff", 30*time.Millisecond, "initial delay before retrying a failed request, at least 1ms")
+	retryMaxBackoff = flag.Duration("retry.max-backoff", 5*time.Second, "maximum delay between retries of a failed request")
+	retryMaxDuration = flag.Duration("retry.max-duration", time.Minute, "how long to retry a failed request before its samples are dropped, 0 disables retries")
+	otlpUrl = flag.String("otlp.url", "", "otlp http metrics endpoint, e.g. http://localhost:4318/v1/metrics")
+	otlpProtocol = flag.String("otlp.protocol", otlp.ProtocolProtobuf, fmt.Sprintf("otlp encoding, %v or %v", otlp.ProtocolProtobuf, otlp.ProtocolJSON))
+	otlpResourceLabels = flag.String("otlp.resource-labels", "job,instance", "comma separated labels that become otlp resource attributes")
+	influxUrl = flag.String("influx.url", "", "influx write url, e.g. http://localhost:8086/write?db=test")
+	graphiteAddress = flag.String("graphite.address", "", "graphite plaintext address, e.g. localhost:2003")
+	graphiteFormat = flag.String("graphite.format", graphite.FormatTagged, fmt.Sprintf("how labels are written to graphite, %v or %v", graphite.FormatTagged, graphite.FormatPath))
 }
 
-func runWriter(wg *sync.WaitGroup, interval time.Duration, stop <-chan bool, parsedUrl *url.URL, rt RealtimeContext) {
-	go func() {
-		for {
-			select {
-			case _ = <-stop:
-				log.Println("stop signal received")
-				wg.Done()
-				return
-			case <-time.After(interval):
+// isWritePath returns true if a path already names a remote write endpoint,
+// e.g. /api/v1/write, /api/v1/push or /api/v1/receive
+func isWritePath(urlPath string) bool {
+	switch path.Base(urlPath) {
+	case "write", "push", "receive":
+		return true
+	}
+	return false
+}
+
+// remoteWriteUrl returns the remote write endpoint of a url. Urls whose path
+// ends with a write endpoint are used as they are, /api/v1/write is appended
+// to all others like it always was.
+func remoteWriteUrl(rawUrl string) (*url.URL, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if parsedUrl.Scheme == "" || parsedUrl.Host == "" {
+		return nil, errors.New(fmt.Sprintf("invalid remote write url: %v", rawUrl))
+	}
+	if !isWritePath(parsedUrl.Path) {
+		parsedUrl.Path = path.Join("/", parsedUrl.Path, "/api/v1/write")
+	}
+	return parsedUrl, nil
+}
+
+// headerFlag collects the headers of a repeated Name: value flag
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	var headers []string
+	for name, value := range h {
+		headers = append(headers, name+": "+value)
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (h headerFlag) Set(value string) error {
+	name, headerValue, found := strings.Cut(value, ":")
+	if !found || strings.TrimSpace(name) == "" {
+		return errors.New(fmt.Sprintf("invalid header: %v", value))
+	}
+	h[strings.TrimSpace(name)] = strings.TrimSpace(headerValue)
+	return nil
+}
+
+// labelFlag collects the labels of a repeated name=value flag
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	var labels []string
+	for name, value := range l {
+		labels = append(labels, name+"="+value)
+	}
+	return strings.Join(labels, ",")
+}
+
+func (l labelFlag) Set(value string) error {
+	name, labelValue, found := strings.Cut(value, "=")
+	if !found || strings.TrimSpace(name) == "" {
+		return errors.New(fmt.Sprintf("invalid label: %v", value))
+	}
+	l[strings.TrimSpace(name)] = labelValue
+	return nil
+}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// mergeExternalLabels returns the external labels of the config file with
+// those of the flags taking precedence
+func mergeExternalLabels(config map[string]string, flags map[string]string) (map[string]string, error) {
+	merged := map[string]string{}
+	for name, value := range config {
+		merged[name] = value
+	}
+	for name, value := range flags {
+		merged[name] = value
+	}
+	for name := range merged {
+		if !labelNamePattern.MatchString(name) || name == "__name__" {
+			return nil, errors.New(fmt.Sprintf("invalid external label name: %v", name))
+		}
+	}
+	return merged, nil
+}
+
+// withExternalLabels appends the external labels in the order of their
+// names. A label that is set both in the series and as an external label is
+// an error, the series would otherwise silently lose one of the values.
+func withExternalLabels(series string, labels []*prometheus.Label, external map[string]string) ([]*prometheus.Label, error) {
+	for _, label := range labels {
+		if _, ok := external[label.Name]; ok {
+			return nil, errors.New(fmt.Sprintf("label %v of series %v conflicts with the external label %v=%v", label.Name, series, label.Name, external[label.Name]))
+		}
+	}
+	var names []string
+	for name := range external {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		labels = append(labels, &prometheus.Label{
+			Name:  name,
+			Value: external[name],
+		})
+	}
+	return labels, nil
+}
+
+// newHTTPClient creates the client of an HTTP sink. At most one kind of
+// authentication can be used, custom headers are set before it so that they
+// can't replace its Authorization header and are included in the signature.
+func newHTTPClient(config ConfigHTTPClient) (*http.Client, error) {
+	authentications := 0
+	for _, set := range []bool{config.BasicAuth != nil, config.Authorization != nil, config.OAuth2 != nil, config.SigV4 != nil} {
+		if set {
+			authentications++
+		}
+	}
+	if authentications > 1 {
+		return nil, errors.New("at most one of basic_auth, authorization, oauth2 and sigv4 can be set")
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if config.TLSConfig != nil {
+		tlsConfig, err := auth.NewTLSConfig(config.TLSConfig.CAFile, config.TLSConfig.CertFile, config.TLSConfig.KeyFile, config.TLSConfig.ServerName, config.TLSConfig.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		tlsTransport := http.DefaultTransport.(*http.Transport).Clone()
+		tlsTransport.TLSClientConfig = tlsConfig
+		transport = tlsTransport
+	}
+	if config.BasicAuth != nil {
+		if config.BasicAuth.Password != "" && config.BasicAuth.PasswordFile != "" {
+			return nil, errors.New("at most one of basic_auth password and password_file can be set")
+		}
+		transport = auth.NewBasicAuth(config.BasicAuth.Username, config.BasicAuth.Password, config.BasicAuth.PasswordFile, transport)
+	}
+	if config.Authorization != nil {
+		if config.Authorization.Credentials != "" && config.Authorization.CredentialsFile != "" {
+			return nil, errors.New("at most one of authorization credentials and credentials_file can be set")
+		}
+		transport = auth.NewAuthorization(config.Authorization.Type, config.Authorization.Credentials, config.Authorization.CredentialsFile, transport)
+	}
+	if config.OAuth2 != nil {
+		if config.OAuth2.TokenURL == "" {
+			return nil, errors.New("missing value: oauth2 token_url")
+		}
+		if config.OAuth2.ClientSecret != "" && config.OAuth2.ClientSecretFile != "" {
+			return nil, errors.New("at most one of oauth2 client_secret and client_secret_file can be set")
+		}
+		transport = auth.NewOAuth2(config.OAuth2.ClientID, config.OAuth2.ClientSecret, config.OAuth2.ClientSecretFile, config.OAuth2.TokenURL, config.OAuth2.Scopes, config.OAuth2.EndpointParams, transport)
+	}
+	if config.SigV4 != nil {
+		region := config.SigV4.Region
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		if region == "" {
+			return nil, errors.New("missing value: sigv4 region")
+		}
+		transport = auth.NewSigV4(region, config.SigV4.AccessKey, config.SigV4.SecretKey, config.SigV4.Profile, config.SigV4.RoleARN, transport)
+	}
+	if len(config.Headers) > 0 {
+		transport = auth.NewHeaders(config.Headers, transport)
+	}
+	return sink.NewHTTPClient(transport), nil
+}
+
+// flagHTTPClient returns the http client settings of the remote write flags
+func flagHTTPClient() ConfigHTTPClient {
+	config := ConfigHTTPClient{
+		Headers: remoteWriteHeaders,
+	}
+	if *basicAuthUsername != "" {
+		config.BasicAuth = &ConfigBasicAuth{
+			Username:     *basicAuthUsername,
+			Password:     *basicAuthPassword,
+			PasswordFile: *basicAuthPasswordFile,
+		}
+	}
+	if *bearerToken != "" || *bearerTokenFile != "" {
+		config.Authorization = &ConfigAuthorization{
+			Credentials:     *bearerToken,
+			CredentialsFile: *bearerTokenFile,
+		}
+	}
+	if *sigV4Region != "" || *sigV4AccessKey != "" || *sigV4Profile != "" || *sigV4RoleARN != "" {
+		config.SigV4 = &ConfigSigV4{
+			Region:    *sigV4Region,
+			AccessKey: *sigV4AccessKey,
+			SecretKey: *sigV4SecretKey,
+			Profile:   *sigV4Profile,
+			RoleARN:   *sigV4RoleARN,
+		}
+	}
+	if *tlsCAFile != "" || *tlsCertFile != "" || *tlsKeyFile != "" || *tlsServerName != "" || *tlsInsecureSkipVerify {
+		config.TLSConfig = &ConfigTLS{
+			CAFile:             *tlsCAFile,
+			CertFile:           *tlsCertFile,
+			KeyFile:            *tlsKeyFile,
+			ServerName:         *tlsServerName,
+			InsecureSkipVerify: *tlsInsecureSkipVerify,
+		}
+	}
+	return config
+}
+
+// flagSinks returns the sinks configured with command line flags
+func flagSinks() ([]sink.Sink, error) {
+	var sinks []sink.Sink
+	if *prometheusUrl != "" {
+		parsedUrl, err := remoteWriteUrl(*prometheusUrl)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := remotewrite.ParsePolicy(*rejectionPolicy)
+		if err != nil {
+			return nil, err
+		}
+		client, err := newHTTPClient(flagHTTPClient())
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink.NewRemoteWrite(parsedUrl, *remoteWriteProtocol, policy, client))
+	}
+	if *otlpUrl != "" {
+		sinks = append(sinks, sink.NewOTLP(*otlpUrl, *otlpProtocol, strings.Split(*otlpResourceLabels, ","), sink.NewHTTPClient(http.DefaultTransport)))
+	}
+	if *influxUrl != "" {
+		sinks = append(sinks, sink.NewInflux(*influxUrl, sink.NewHTTPClient(http.DefaultTransport)))
+	}
+	if *graphiteAddress != "" {
+		sinks = append(sinks, sink.NewGraphite(*graphiteAddress, *graphiteFormat))
+	}
+	return sinks, nil
+}
+
+// newRelabelRules creates relabeling rules with the defaults of Prometheus
+func newRelabelRules(configs []ConfigRelabel) (relabel.Rules, error) {
+	var rules relabel.Rules
+	for _, config := range configs {
+		action := config.Action
+		if action == "" {
+			action = relabel.ActionReplace
+		}
+		separator := relabel.DefaultSeparator
+		if config.Separator != nil {
+			separator = *config.Separator
+		}
+		regex := relabel.DefaultRegex
+		if config.Regex != nil {
+			regex = *config.Regex
+		}
+		replacement := relabel.DefaultReplacement
+		if config.Replacement != nil {
+			replacement = *config.Replacement
+		}
+		rule, err := relabel.NewRule(action, config.SourceLabels, separator, config.TargetLabel, regex, config.Modulus, replacement)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// newSink creates a sink from its configuration in the sinks section, if
+// series selectors are set only matching series are written to it. The
+// selectors match the labels before the rules of the sink are applied.
+func newSink(config ConfigSink) (sink.Sink, error) {
+	selectors, err := selector.ParseAll(config.Series)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := newRelabelRules(config.WriteRelabelConfigs)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newDestination(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) > 0 {
+		s = sink.NewRelabel(s, rules)
+	}
+	if len(selectors) > 0 {
+		return sink.NewFilter(s, selectors), nil
+	}
+	return s, nil
+}
+
+// newDestination creates the sink of a type without filtering its series
+func newDestination(config ConfigSink) (sink.Sink, error) {
+	client, err := newHTTPClient(config.ConfigHTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case SinkRemoteWrite:
+		if config.Url == "" {
+			return nil, errors.New("missing value: sink url")
+		}
+		protocol := config.Protocol
+		if protocol == "" {
+			protocol = remotewrite.ProtocolV1
+		}
+		if !remotewrite.IsValidProtocol(protocol) {
+			return nil, errors.New(fmt.Sprintf("invalid value: sink protocol: %v", protocol))
+		}
+		// the rejections of a sink override those of the flag
+		policy, err := remotewrite.ParsePolicy(*rejectionPolicy + "," + config.Rejections)
+		if err != nil {
+			return nil, err
+		}
+		parsedUrl, err := remoteWriteUrl(config.Url)
+		if err != nil {
+			return nil, err
+		}
+		return sink.NewRemoteWrite(parsedUrl, protocol, policy, client), nil
+	case SinkOTLP:
+		if config.Url == "" {
+			return nil, errors.New("missing value: sink url")
+		}
+		protocol := config.Protocol
+		if protocol == "" {
+			protocol = otlp.ProtocolProtobuf
+		}
+		if !otlp.IsValidProtocol(protocol) {
+			return nil, errors.New(fmt.Sprintf("invalid value: sink protocol: %v", protocol))
+		}
+		resourceLabels := config.ResourceLabels
+		if resourceLabels == nil {
+			resourceLabels = []string{"job", "instance"}
+		}
+		return sink.NewOTLP(config.Url, protocol, resourceLabels, client), nil
+	case SinkInflux:
+		if config.Url == "" {
+			return nil, errors.New("missing value: sink url")
+		}
+		return sink.NewInflux(config.Url, client), nil
+	case SinkGraphite:
+		if config.Address == "" {
+			return nil, errors.New("missing value: sink address")
+		}
+		format := config.Format
+		if format == "" {
+			format = graphite.FormatTagged
+		}
+		if !graphite.IsValidFormat(format) {
+			return nil, errors.New(fmt.Sprintf("invalid value: sink format: %v", format))
+		}
+		return sink.NewGraphite(config.Address, format), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown sink type: %v", config.Type))
+	}
+}
+
+// registry holds the realtime series when they are scraped instead of sent
+var registry *exposition.Registry
+
+// destination queues the realtime series for all configured sinks
+var destination sink.Sink
+
+// relabelRules are the global relabeling rules, they apply to the histogram
+// and summary series derived from a definition as well, right before the
+// rules of each sink
+var relabelRules relabel.Rules
+
+// relabelWriteRequest returns a copy of the request with the global rules
+// applied to its series
+func relabelWriteRequest(wr *prometheus.WriteRequest) *prometheus.WriteRequest {
+	if len(relabelRules) == 0 {
+		return wr
+	}
+	relabeled := &prometheus.WriteRequest{Metadata: wr.Metadata}
+	for _, ts := range wr.Timeseries {
+		labels, keep := relabelRules.Apply(ts.Labels)
+		if !keep {
+			continue
+		}
+		relabeled.Timeseries = append(relabeled.Timeseries, &prometheus.TimeSeries{
+			Labels:     labels,
+			Samples:    ts.Samples,
+			Exemplars:  ts.Exemplars,
+			Histograms: ts.Histograms,
+		})
+	}
+	return relabeled
+}
+
+func writeSample(rt RealtimeContext, value float64, timestamp int64) error {
+	wr := &prometheus.WriteRequest{}
+	for _, series := range rt.series {
+		wr.Timeseries = append(wr.Timeseries, &prometheus.TimeSeries{
+			Labels: series.Labels,
+		})
+	}
+	err := appendValue(wr.Timeseries, rt.aggregation, rt.exemplars, value, timestamp)
+	if err != nil {
+		return errors.New(fmt.Sprintf("realtime series %v: %v", metricName(rt.series[0].Labels), err))
+	}
+	wr.Metadata = append(wr.Metadata, rt.metadata)
+	wr = relabelWriteRequest(wr)
+	if registry != nil {
+		registry.Update(wr)
+		return nil
+	}
+	err = destination.Write(context.TODO(), sink.FromWriteRequest(wr, rt.created, rt.tenant))
+	if err != nil {
+		return errors.New(fmt.Sprintf("error writing series %v: %v", wr.String(), err))
+	}
+	log.Println(fmt.Sprintf("next value: %v", wr.String()))
+	return nil
+}
+
+// flushDestination sends the queued realtime series. Failed writes are
+// logged and the next interval is written anyway, unless the samples were
+// rejected with the fail policy.
+func flushDestination() error {
+	err := destination.Flush(context.TODO())
+	if err == nil {
+		return nil
+	}
+	var fatal *sink.FatalError
+	if errors.As(err, &fatal) {
+		return errors.New(fmt.Sprintf("error writing series: %v", err))
+	}
+	log.Println(fmt.Sprintf("error writing series: %v", err))
+	return nil
+}
+
+// runWriter writes the next value of all realtime series every interval until
+// it is stopped. The samples of one interval are batched into as few requests
+// as possible and sent before the next interval starts. It returns an error
+// when a series fails, e.g. a counter that decreased, or its samples are
+// rejected with the fail policy.
+func runWriter(interval time.Duration, stop <-chan bool, realtime []RealtimeContext) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case _ = <-stop:
+			log.Println("stop signal received")
+			if *staleOnStop {
+				// mark the series as gone so that Prometheus stops returning them
+				timestamp := time.Now().UnixMilli()
+				for _, rt := range realtime {
+					err := writeSample(rt, progression.StaleNaN, timestamp)
+					if err != nil {
+						log.Println(err)
+					}
+				}
+			}
+			return flushDestination()
+		case <-ticker.C:
+			for _, rt := range realtime {
 				valid, value, timestamp := rt.rt.Next()
+				if err := rt.rt.Err(); err != nil {
+					// send what was written so far before stopping
+					if err := flushDestination(); err != nil {
+						log.Println(err)
+					}
+					return errors.New(fmt.Sprintf("realtime series %v: %v", metricName(rt.series[0].Labels), err))
+				}
 				if valid && value != nil {
-					timeseries := prometheus.TimeSeries{}
-					timeseries.Labels = rt.ts.Labels
-					timeseries.Samples = append(timeseries.Samples, &prometheus.Sample{
-						Value:     *value,
-						Timestamp: timestamp,
-					})
-					wr := &prometheus.WriteRequest{}
-					wr.Timeseries = append(wr.Timeseries, &timeseries)
-					err := sendRequest(wr, parsedUrl)
+					err := writeSample(rt, *value, timestamp)
 					if err != nil {
-						log.Fatalf("error writing series %v: %v", wr.String(), err)
-					} else {
-						log.Println(fmt.Sprintf("next value: %v", wr.String()))
+						log.Println(err)
 					}
 				}
 			}
+			err := flushDestination()
+			if err != nil {
+				return err
+			}
 		}
+	}
+}
+
+// runReceiver runs the built-in remote write receiver until it is stopped
+func runReceiver(args []string) {
+	flags := flag.NewFlagSet("receive", flag.ExitOnError)
+	listenAddress := flags.String("listen.address", ":9090", "address to accept remote write requests on")
+	dumpFile := flags.String("dump.file", "", "file to write the received series to when stopped")
+	flags.Parse(args)
+
+	rcv := receiver.NewReceiver()
+	server := &http.Server{
+		Addr:    *listenAddress,
+		Handler: rcv.Handler(),
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGABRT, syscall.SIGINT)
+	go func() {
+		<-sigs
+		server.Close()
 	}()
 
+	log.Println(fmt.Sprintf("receiving remote write requests on %v%v", *listenAddress, receiver.WritePath))
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	if *dumpFile != "" {
+		err = rcv.Dump(*dumpFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprintf("received series written to %v", *dumpFile))
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "receive" {
+		runReceiver(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
-	if prometheusUrl == nil || *prometheusUrl == "" {
-		fmt.Println("missing value: prometheus.url")
+	outputType, outputFile, _ := strings.Cut(*output, ":")
+	if outputType != OutputRemoteWrite && outputType != OutputOpenMetrics && outputType != OutputTSDB {
+		fmt.Println(fmt.Sprintf("invalid value: output: %v", *output))
+		os.Exit(1)
+	}
+
+	if outputType != OutputRemoteWrite && outputFile == "" {
+		fmt.Println("missing value: output file")
 		os.Exit(1)
 	}
 
@@ -138,17 +995,90 @@ func main() {
 		os.Exit(1)
 	}
 
-	parsedUrl, err := url.Parse(*prometheusUrl)
-	if err != nil {
-		panic(err)
+	if !remotewrite.IsValidProtocol(*remoteWriteProtocol) {
+		fmt.Println(fmt.Sprintf("invalid value: remote-write.protocol: %v", *remoteWriteProtocol))
+		os.Exit(1)
+	}
+
+	if _, err := remotewrite.ParsePolicy(*rejectionPolicy); err != nil {
+		fmt.Println(fmt.Sprintf("invalid value: remote-write.rejections: %v", err))
+		os.Exit(1)
 	}
 
-	parsedUrl.Path = path.Join(parsedUrl.Path, "/api/v1/write")
+	if !otlp.IsValidProtocol(*otlpProtocol) {
+		fmt.Println(fmt.Sprintf("invalid value: otlp.protocol: %v", *otlpProtocol))
+		os.Exit(1)
+	}
+
+	if !graphite.IsValidFormat(*graphiteFormat) {
+		fmt.Println(fmt.Sprintf("invalid value: graphite.format: %v", *graphiteFormat))
+		os.Exit(1)
+	}
 
 	raw, _ := os.ReadFile(*configFile)
 	root := ConfigRoot{}
 	yaml.Unmarshal(raw, &root)
 
+	sinks, err := flagSinks()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, config := range root.RemoteWrite {
+		s, err := newSink(ConfigSink{
+			ConfigHTTPClient:    config.ConfigHTTPClient,
+			Type:                SinkRemoteWrite,
+			Url:                 config.Url,
+			Protocol:            config.Protocol,
+			Rejections:          config.Rejections,
+			Series:              config.Series,
+			WriteRelabelConfigs: config.WriteRelabelConfigs,
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, s)
+	}
+	for _, config := range root.Sinks {
+		s, err := newSink(config)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, s)
+	}
+
+	if len(sinks) == 0 && *scrapeAddress == "" && outputType == OutputRemoteWrite {
+		fmt.Println("missing value: prometheus.url, remote_write or sinks")
+		os.Exit(1)
+	}
+
+	retryStats := &sink.RetryStats{}
+	for i, s := range sinks {
+		sinks[i] = sink.NewTenants(sink.NewRetry(s, *retryMinBackoff, *retryMaxBackoff, *retryMaxDuration, retryStats))
+	}
+	fanout := sink.NewFanout(sinks...)
+
+	if *metricsAddress != "" {
+		if *metricsAddress == *scrapeAddress {
+			log.Fatal("metrics.address and scrape.address must differ")
+		}
+		metrics := exposition.NewRegistry()
+		metrics.Register(func() *prometheus.WriteRequest {
+			return retryStats.WriteRequest(time.Now().UnixMilli())
+		})
+		mux := http.NewServeMux()
+		mux.Handle(exposition.MetricsPath, metrics)
+		go func() {
+			err := http.ListenAndServe(*metricsAddress, mux)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+		log.Println(fmt.Sprintf("serving metrics on %v%v", *metricsAddress, exposition.MetricsPath))
+	}
+
 	interval, err := time.ParseDuration(root.Interval)
 	if err != nil {
 		panic(err)
@@ -182,10 +1112,22 @@ func main() {
 		log.Println("lua scripting enabled")
 	}
 
+	relabelRules, err = newRelabelRules(root.WriteRelabelConfigs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	external, err := mergeExternalLabels(root.ExternalLabels, externalLabels)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	scanner := ingest.NewTimeseriesScanner()
 	progScanner := progression.NewProgressionScanner()
 
 	var writeRequests []prometheus.WriteRequest
+	var writeTenants []string
 	var realtimeProgressions []RealtimeContext
 
 	for _, ts := range root.Series {
@@ -194,73 +1136,185 @@ func main() {
 			panic(err)
 		}
 
+		tenant := root.Tenant
+		if ts.Tenant != "" {
+			tenant = ts.Tenant
+		}
+
 		parser := ingest.NewTimeseriesParser(tokens)
 		parsedTimeseries, err := parser.Parse()
 		if err != nil {
 			panic(err)
 		}
 
+		// external labels are added before relabeling like in Prometheus
+		labels, err := withExternalLabels(ts.Series, parsedTimeseries.Labels, external)
+		if err != nil {
+			panic(err)
+		}
+		parsedTimeseries.Labels = labels
+
+		metadata, err := metricMetadata(ts, parsedTimeseries.Labels)
+		if err != nil {
+			panic(err)
+		}
+
+		aggregation, err := newAggregation(ts, luaState)
+		if err != nil {
+			panic(err)
+		}
+
+		exemplars, err := newExemplars(ts, luaState)
+		if err != nil {
+			panic(err)
+		}
+
+		series := []*prometheus.TimeSeries{parsedTimeseries}
+		if aggregation != nil {
+			series = aggregation.Series(parsedTimeseries.Labels)
+		}
+
 		if ts.Progression != "" {
 			progTokens, err := progScanner.Scan(ts.Progression)
+			if err != nil {
+				panic(errors.New(fmt.Sprintf("invalid progression %q of series %v: %v", ts.Progression, ts.Series, err)))
+			}
 			progParser := progression.NewProgressionParser(progTokens)
 			progressions, err := progParser.Parse(interval)
 			if err != nil {
-				panic(err)
+				panic(errors.New(fmt.Sprintf("invalid progression %q of series %v: %v", ts.Progression, ts.Series, err)))
 			}
 
 			progressions.WithLuaState(luaState)
+			if ts.Type == SeriesTypeCounter {
+				progressions.AsCounter()
+			}
 			writeRequest := prometheus.WriteRequest{}
-			writeRequest.Timeseries = append(writeRequest.Timeseries, parsedTimeseries)
-			writeRequest.Metadata = append(writeRequest.Metadata, &prometheus.MetricMetadata{
-				Type: prometheus.MetricMetadata_GAUGE,
-			})
+			writeRequest.Timeseries = append(writeRequest.Timeseries, series...)
+			writeRequest.Metadata = append(writeRequest.Metadata, metadata)
 
 			for true {
 				valid, value, timestamp := progressions.Next()
 				if !valid {
+					if err := progressions.Err(); err != nil {
+						panic(errors.New(fmt.Sprintf("invalid progression %q of series %v: %v", ts.Progression, ts.Series, err)))
+					}
 					break
 				}
 
 				if value != nil {
-					parsedTimeseries.Samples = append(parsedTimeseries.Samples, &prometheus.Sample{
-						Value:     *value,
-						Timestamp: timestamp,
-					})
+					err := appendValue(series, aggregation, exemplars, *value, timestamp)
+					if err != nil {
+						panic(errors.New(fmt.Sprintf("invalid progression %q of series %v: %v", ts.Progression, ts.Series, err)))
+					}
 				}
 			}
+			writeRequest.Timeseries = relabelWriteRequest(&writeRequest).Timeseries
 			writeRequests = append(writeRequests, writeRequest)
+			writeTenants = append(writeTenants, tenant)
 		}
 
 		if ts.Realtime != "" {
-			rtTokens, err := progScanner.Scan(ts.Realtime)
+			rtTokens, err := progScanner.ScanRealtime(ts.Realtime)
+			if err != nil {
+				panic(errors.New(fmt.Sprintf("invalid realtime series %q of series %v: %v", ts.Realtime, ts.Series, err)))
+			}
 			progParser := progression.NewProgressionParser(rtTokens)
 			rt, err := progParser.ParseRealtime()
 			if err != nil {
-				panic(err)
+				panic(errors.New(fmt.Sprintf("invalid realtime series %q of series %v: %v", ts.Realtime, ts.Series, err)))
 			}
 			rt.WithLuaState(luaState)
+			if ts.Type == SeriesTypeCounter {
+				rt.AsCounter()
+			}
+			if aggregation != nil {
+				// realtime series keep their own observations
+				aggregation, err = newAggregation(ts, luaState)
+				if err != nil {
+					panic(err)
+				}
+			}
+			// and count their own samples for exemplars
+			exemplars, err = newExemplars(ts, luaState)
+			if err != nil {
+				panic(err)
+			}
 			realtimeProgressions = append(realtimeProgressions, RealtimeContext{
-				rt: rt,
-				ts: parsedTimeseries,
+				rt:          rt,
+				series:      series,
+				metadata:    metadata,
+				aggregation: aggregation,
+				exemplars:   exemplars,
+				created:     time.Now().UnixMilli(),
+				tenant:      tenant,
 			})
 		}
 
 	}
 
-	for _, wr := range writeRequests {
-		err = sendRequest(&wr, parsedUrl)
+	if outputType == OutputOpenMetrics {
+		file, err := os.Create(outputFile)
 		if err != nil {
-			log.Fatalf("error writing series %v: %v", wr.String(), err)
+			log.Fatalf("error creating output file: %v", err)
 		}
+		err = exposition.WriteOpenMetrics(file, writeRequests)
+		if err != nil {
+			log.Fatalf("error writing output file: %v", err)
+		}
+		file.Close()
+
+		log.Println(fmt.Sprintf("done writing precalculated series to %v", outputFile))
+	} else if outputType == OutputTSDB {
+		err = os.MkdirAll(outputFile, 0755)
+		if err != nil {
+			log.Fatalf("error creating output directory: %v", err)
+		}
+		blocks, err := tsdb.WriteBlocks(outputFile, writeRequests, *blockDuration)
+		if err != nil {
+			log.Fatalf("error writing blocks: %v", err)
+		}
+
+		log.Println(fmt.Sprintf("done writing precalculated series to %v blocks in %v", len(blocks), outputFile))
+	} else if len(sinks) > 0 {
+		for i, wr := range writeRequests {
+			err = fanout.Write(context.TODO(), sink.FromWriteRequest(&wr, firstTimestamp(&wr), writeTenants[i]))
+			if err != nil {
+				log.Fatalf("error writing series %v: %v", wr.String(), err)
+			}
+		}
+		err = fanout.Flush(context.TODO())
+		if err != nil {
+			log.Fatalf("error writing series: %v", err)
+		}
+
+		log.Println("done writing precalculated series")
+	} else if len(writeRequests) > 0 {
+		log.Println("precalculated series can't be scraped, set prometheus.url or sinks to send them")
 	}
 
-	log.Println("done writing precalculated series")
+	if *scrapeAddress != "" {
+		registry = exposition.NewRegistry()
+		mux := http.NewServeMux()
+		mux.Handle(exposition.MetricsPath, registry)
+		go func() {
+			err := http.ListenAndServe(*scrapeAddress, mux)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+		log.Println(fmt.Sprintf("serving realtime series on %v%v", *scrapeAddress, exposition.MetricsPath))
+	}
 
 	if len(realtimeProgressions) > 0 {
+		if len(sinks) == 0 && *scrapeAddress == "" {
+			log.Fatal("realtime series require either sinks or scrape.address")
+		}
+
 		log.Println("entering realtime mode")
-		wg := &sync.WaitGroup{}
+		destination = sink.NewQueue(fanout, *maxSamplesPerSend, *shards)
 		stop := make(chan bool)
-		sigs := make(chan os.Signal)
+		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGABRT, syscall.SIGINT)
 		go func() {
 			sig := <-sigs
@@ -270,13 +1324,27 @@ func main() {
 			}
 		}()
 
-		for _, rt := range realtimeProgressions {
-			wg.Add(1)
-			rt := rt
-			log.Print("starting remote write goroutine")
-			runWriter(wg, interval, stop, parsedUrl, rt)
-		}
+		log.Println(fmt.Sprintf("sending up to %v samples per request from %v shards", *maxSamplesPerSend, *shards))
+		writerErr := runWriter(interval, stop, realtimeProgressions)
 
-		wg.Wait()
+		// closing the queue closes the sinks as well
+		err = destination.Close()
+		if writerErr != nil {
+			if err != nil {
+				log.Println(err)
+			}
+			if len(sinks) > 0 {
+				log.Println(retryStats.String())
+			}
+			log.Fatal(writerErr)
+		}
+	} else {
+		err = fanout.Close()
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	if len(sinks) > 0 {
+		log.Println(retryStats.String())
 	}
 }
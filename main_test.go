@@ -0,0 +1,127 @@
+package main
+
+import (
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"write/progression"
+	"write/remotewrite"
+	"write/sink"
+)
+
+func TestRemoteWriteUrl(t *testing.T) {
+	for input, expected := range map[string]string{
+		"http://localhost:9090":                                 "http://localhost:9090/api/v1/write",
+		"http://localhost:9090/":                                "http://localhost:9090/api/v1/write",
+		"http://proxy/prometheus":                               "http://proxy/prometheus/api/v1/write",
+		"http://proxy/prometheus/?tenant=a":                     "http://proxy/prometheus/api/v1/write?tenant=a",
+		"http://localhost:9090/api/v1/write":                    "http://localhost:9090/api/v1/write",
+		"http://mimir:8080/api/v1/push":                         "http://mimir:8080/api/v1/push",
+		"http://thanos:19291/api/v1/receive":                    "http://thanos:19291/api/v1/receive",
+		"http://vminsert:8480/insert/0/prometheus/api/v1/write": "http://vminsert:8480/insert/0/prometheus/api/v1/write",
+	} {
+		got, err := remoteWriteUrl(input)
+		if err != nil {
+			t.Errorf("%v: %v", input, err)
+			continue
+		}
+		if got.String() != expected {
+			t.Errorf("%v: expected %v, got %v", input, expected, got)
+		}
+	}
+
+	for _, input := range []string{"localhost:9090", "/api/v1/write", "http://"} {
+		_, err := remoteWriteUrl(input)
+		if err == nil {
+			t.Errorf("expected an error for %v", input)
+		}
+	}
+}
+
+func TestRelabelDerivedSeries(t *testing.T) {
+	regex, replacement := "latency_(sum|count)", "eu-1"
+	rules, err := newRelabelRules([]ConfigRelabel{
+		{SourceLabels: []string{"__name__"}, Regex: &regex, Action: "drop"},
+		{TargetLabel: "cluster", Replacement: &replacement},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relabelRules = rules
+	t.Cleanup(func() {
+		relabelRules = nil
+	})
+
+	wr := &prometheus.WriteRequest{}
+	for _, name := range []string{"latency_bucket", "latency_sum", "latency_count"} {
+		wr.Timeseries = append(wr.Timeseries, &prometheus.TimeSeries{
+			Labels:  []*prometheus.Label{{Name: "__name__", Value: name}},
+			Samples: []*prometheus.Sample{{Value: 1, Timestamp: 1000}},
+		})
+	}
+	relabeled := relabelWriteRequest(wr)
+	if len(relabeled.Timeseries) != 1 || metricName(relabeled.Timeseries[0].Labels) != "latency_bucket" {
+		t.Fatalf("expected only the bucket series, got %v", relabeled.Timeseries)
+	}
+	if labels := relabeled.Timeseries[0].Labels; len(labels) != 2 || labels[1].Name != "cluster" || len(relabeled.Timeseries[0].Samples) != 1 {
+		t.Errorf("unexpected series %v", relabeled.Timeseries[0])
+	}
+	if len(wr.Timeseries[0].Labels) != 1 {
+		t.Errorf("the request passed in was changed: %v", wr.Timeseries[0])
+	}
+}
+
+func TestRunWriterStopsOnFailedRejection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid label name", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := remotewrite.ParsePolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	destination = sink.NewQueue(sink.NewRemoteWrite(u, remotewrite.ProtocolV1, policy, server.Client()), 100, 1)
+	t.Cleanup(func() {
+		destination.Close()
+		destination = nil
+	})
+
+	tokens, err := progression.NewProgressionScanner().ScanRealtime("1+0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, err := progression.NewProgressionParser(tokens).ParseRealtime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	realtime := []RealtimeContext{{
+		rt:       rt,
+		series:   []*prometheus.TimeSeries{{Labels: []*prometheus.Label{{Name: "__name__", Value: "up"}}}},
+		metadata: &prometheus.MetricMetadata{MetricFamilyName: "up", Type: prometheus.MetricMetadata_GAUGE},
+	}}
+
+	result := make(chan error, 1)
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		result <- runWriter(10*time.Millisecond, stop, realtime)
+	}()
+
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "invalid_labels") {
+			t.Fatalf("expected the invalid_labels rejection, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer kept running after a rejection with the fail policy")
+	}
+}
@@ -0,0 +1,38 @@
+package observation
+
+import (
+	"errors"
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+)
+
+// MaxObservations is the largest number of observations made in one interval
+const MaxObservations = 1000000
+
+// Aggregation turns observations into the samples of one or more series
+type Aggregation interface {
+	// Observe draws n observations from the distribution, fractions of an
+	// observation are carried over to the next call
+	Observe(n float64) error
+	// Series returns the series derived from the given labels
+	Series(labels []*prometheus.Label) []*prometheus.TimeSeries
+	// Append appends the current state to the series returned by Series
+	Append(series []*prometheus.TimeSeries, timestamp int64)
+}
+
+// pending is the fraction of an observation carried over between intervals,
+// observing 0.5 every interval makes one observation every other interval
+type pending float64
+
+// take adds n to the pending fraction and returns the number of whole
+// observations to make
+func (p *pending) take(n float64) (int, error) {
+	if math.IsNaN(n) || n < 0 || n > MaxObservations {
+		return 0, errors.New(fmt.Sprintf("invalid number of observations %v, must be between 0 and %v", n, MaxObservations))
+	}
+	total := float64(*p) + n
+	whole := math.Floor(total)
+	*p = pending(total - whole)
+	return int(whole), nil
+}
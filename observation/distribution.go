@@ -0,0 +1,91 @@
+package observation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/go-lua"
+	"math/rand"
+)
+
+const (
+	DistributionNormal      = "normal"
+	DistributionExponential = "exponential"
+	DistributionFunction    = "function"
+)
+
+// Distribution provides the values of individual observations
+type Distribution interface {
+	Sample() float64
+}
+
+type Normal struct {
+	Mean   float64
+	StdDev float64
+	rnd    *rand.Rand
+}
+
+type Exponential struct {
+	Mean float64
+	rnd  *rand.Rand
+}
+
+// Function invokes a Lua function for every observation. The function is
+// passed the number of observations made so far.
+type Function struct {
+	Fn           string
+	timesAlready float64
+	luaState     *lua.State
+}
+
+func NewDistribution(distributionType string, mean float64, stdDev float64, fn string, seed int64, luaState *lua.State) (Distribution, error) {
+	rnd := rand.New(rand.NewSource(seed))
+	switch distributionType {
+	case DistributionNormal:
+		return &Normal{
+			Mean:   mean,
+			StdDev: stdDev,
+			rnd:    rnd,
+		}, nil
+	case DistributionExponential:
+		if mean <= 0 {
+			return nil, errors.New("exponential distribution requires a positive mean")
+		}
+		return &Exponential{
+			Mean: mean,
+			rnd:  rnd,
+		}, nil
+	case DistributionFunction:
+		if fn == "" {
+			return nil, errors.New("function distribution requires a function name")
+		}
+		if luaState == nil {
+			return nil, errors.New("function distribution requires scripting to be enabled")
+		}
+		return &Function{
+			Fn:       fn,
+			luaState: luaState,
+		}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown distribution: %v", distributionType))
+	}
+}
+
+func (d *Normal) Sample() float64 {
+	return d.rnd.NormFloat64()*d.StdDev + d.Mean
+}
+
+func (d *Exponential) Sample() float64 {
+	return d.rnd.ExpFloat64() * d.Mean
+}
+
+func (d *Function) Sample() float64 {
+	d.luaState.Global(d.Fn)
+	d.luaState.PushNumber(d.timesAlready)
+	d.luaState.Call(1, 1)
+	lua.CheckNumber(d.luaState, d.luaState.Top())
+	val, _ := d.luaState.ToNumber(d.luaState.Top())
+	// empty stack
+	d.luaState.Pop(d.luaState.Top())
+	d.timesAlready++
+	return val
+}
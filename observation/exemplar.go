@@ -0,0 +1,134 @@
+package observation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/go-lua"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ExemplarValueSample = "sample"
+
+	// label values that are replaced with random ids for every exemplar
+	ExemplarTraceId = "$trace_id"
+	ExemplarSpanId  = "$span_id"
+)
+
+// Exemplified is implemented by aggregations that can attach exemplars. It
+// returns the index of the series that contains the last observation and
+// its value, or false if nothing was observed.
+type Exemplified interface {
+	LastObservation() (int, float64, bool)
+}
+
+// Exemplars creates an exemplar for every nth sample of a series
+type Exemplars struct {
+	every    int
+	labels   map[string]string
+	value    string
+	constant float64
+	count    int
+	rnd      *rand.Rand
+	luaState *lua.State
+}
+
+// NewExemplars creates exemplars with the given labels. The value is either
+// the sample value, a constant number or a Lua function in parentheses that
+// is passed the sample value and the number of exemplars created so far.
+func NewExemplars(every int, labels map[string]string, value string, seed int64, luaState *lua.State) (*Exemplars, error) {
+	if every < 1 {
+		every = 1
+	}
+
+	if len(labels) == 0 {
+		return nil, errors.New("exemplars require at least one label")
+	}
+
+	exemplars := &Exemplars{
+		every:    every,
+		labels:   labels,
+		value:    value,
+		rnd:      rand.New(rand.NewSource(seed)),
+		luaState: luaState,
+	}
+
+	if value == "" {
+		exemplars.value = ExemplarValueSample
+	} else if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		if luaState == nil {
+			return nil, errors.New("exemplar value functions require scripting to be enabled")
+		}
+		exemplars.value = strings.TrimSuffix(strings.TrimPrefix(value, "("), ")")
+	} else if value != ExemplarValueSample {
+		constant, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid exemplar value: %v", value))
+		}
+		exemplars.constant = constant
+		exemplars.value = ""
+	}
+
+	return exemplars, nil
+}
+
+// Next returns an exemplar for the sample if it is due, or nil
+func (e *Exemplars) Next(sample float64, timestamp int64) *prometheus.Exemplar {
+	e.count++
+	if e.count%e.every != 0 {
+		return nil
+	}
+
+	exemplar := &prometheus.Exemplar{
+		Timestamp: timestamp,
+	}
+
+	switch e.value {
+	case ExemplarValueSample:
+		exemplar.Value = sample
+	case "":
+		exemplar.Value = e.constant
+	default:
+		e.luaState.Global(e.value)
+		e.luaState.PushNumber(sample)
+		e.luaState.PushNumber(float64(e.count / e.every))
+		e.luaState.Call(2, 1)
+		lua.CheckNumber(e.luaState, e.luaState.Top())
+		exemplar.Value, _ = e.luaState.ToNumber(e.luaState.Top())
+		// empty stack
+		e.luaState.Pop(e.luaState.Top())
+	}
+
+	// sort the labels to send them in a stable order
+	var names []string
+	for name := range e.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := e.labels[name]
+		switch value {
+		case ExemplarTraceId:
+			value = e.randomId(16)
+		case ExemplarSpanId:
+			value = e.randomId(8)
+		}
+		exemplar.Labels = append(exemplar.Labels, &prometheus.Label{
+			Name:  name,
+			Value: value,
+		})
+	}
+
+	return exemplar
+}
+
+func (e *Exemplars) randomId(size int) string {
+	id := make([]byte, size)
+	e.rnd.Read(id)
+	return fmt.Sprintf("%x", id)
+}
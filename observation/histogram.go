@@ -0,0 +1,146 @@
+package observation
+
+import (
+	"errors"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ClassicHistogram accumulates observations into cumulative buckets and
+// derives the _bucket, _sum and _count series of a classic histogram
+type ClassicHistogram struct {
+	bounds       []float64
+	counts       []uint64
+	sum          float64
+	count        uint64
+	last         *float64
+	distribution Distribution
+	pending      pending
+}
+
+func NewClassicHistogram(bounds []float64, distribution Distribution) (*ClassicHistogram, error) {
+	if len(bounds) == 0 {
+		return nil, errors.New("histogram requires at least one bucket")
+	}
+
+	sorted := append([]float64{}, bounds...)
+	sort.Float64s(sorted)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] == sorted[i-1] {
+			return nil, errors.New("histogram bucket boundaries must be unique")
+		}
+	}
+
+	// the +Inf bucket is always present
+	if !math.IsInf(sorted[len(sorted)-1], 1) {
+		sorted = append(sorted, math.Inf(1))
+	}
+
+	return &ClassicHistogram{
+		bounds:       sorted,
+		counts:       make([]uint64, len(sorted)),
+		distribution: distribution,
+	}, nil
+}
+
+func (h *ClassicHistogram) Observe(n float64) error {
+	count, err := h.pending.take(n)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		value := h.distribution.Sample()
+		if math.IsNaN(value) {
+			continue
+		}
+		for j, bound := range h.bounds {
+			if value <= bound {
+				h.counts[j]++
+			}
+		}
+		h.sum += value
+		h.count++
+		h.last = &value
+	}
+	return nil
+}
+
+func (h *ClassicHistogram) LastObservation() (int, float64, bool) {
+	if h.last == nil {
+		return 0, 0, false
+	}
+	value := *h.last
+	h.last = nil
+
+	// exemplars belong to the smallest bucket that contains the value
+	for i, bound := range h.bounds {
+		if value <= bound {
+			return i, value, true
+		}
+	}
+	return len(h.bounds) - 1, value, true
+}
+
+func (h *ClassicHistogram) Append(series []*prometheus.TimeSeries, timestamp int64) {
+	var values []float64
+	for _, count := range h.counts {
+		values = append(values, float64(count))
+	}
+	values = append(values, h.sum, float64(h.count))
+	appendSamples(series, values, timestamp)
+}
+
+func (h *ClassicHistogram) Series(labels []*prometheus.Label) []*prometheus.TimeSeries {
+	var series []*prometheus.TimeSeries
+	for _, bound := range h.bounds {
+		bucketLabels := withSuffix(labels, "_bucket")
+		bucketLabels = append(bucketLabels, &prometheus.Label{
+			Name:  "le",
+			Value: formatFloat(bound),
+		})
+		series = append(series, &prometheus.TimeSeries{
+			Labels: bucketLabels,
+		})
+	}
+	series = append(series, &prometheus.TimeSeries{
+		Labels: withSuffix(labels, "_sum"),
+	})
+	series = append(series, &prometheus.TimeSeries{
+		Labels: withSuffix(labels, "_count"),
+	})
+	return series
+}
+
+func appendSamples(series []*prometheus.TimeSeries, values []float64, timestamp int64) {
+	for i, value := range values {
+		series[i].Samples = append(series[i].Samples, &prometheus.Sample{
+			Value:     value,
+			Timestamp: timestamp,
+		})
+	}
+}
+
+// withSuffix copies the labels and appends a suffix to the metric name
+func withSuffix(labels []*prometheus.Label, suffix string) []*prometheus.Label {
+	var result []*prometheus.Label
+	for _, label := range labels {
+		value := label.Value
+		if label.Name == "__name__" {
+			value = value + suffix
+		}
+		result = append(result, &prometheus.Label{
+			Name:  label.Name,
+			Value: value,
+		})
+	}
+	return result
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,139 @@
+package observation
+
+import (
+	"errors"
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+	"sort"
+)
+
+const (
+	NativeHistogramMinSchema = -4
+	NativeHistogramMaxSchema = 8
+)
+
+// NativeHistogram accumulates observations into the exponential buckets of
+// a native (sparse) histogram
+type NativeHistogram struct {
+	schema        int32
+	zeroThreshold float64
+	zeroCount     uint64
+	positive      map[int32]uint64
+	negative      map[int32]uint64
+	sum           float64
+	count         uint64
+	last          *float64
+	distribution  Distribution
+	pending       pending
+}
+
+func NewNativeHistogram(schema int32, zeroThreshold float64, distribution Distribution) (*NativeHistogram, error) {
+	if schema < NativeHistogramMinSchema || schema > NativeHistogramMaxSchema {
+		return nil, errors.New(fmt.Sprintf("native histogram schema must be between %v and %v", NativeHistogramMinSchema, NativeHistogramMaxSchema))
+	}
+
+	if zeroThreshold < 0 {
+		return nil, errors.New("native histogram zero threshold must not be negative")
+	}
+
+	return &NativeHistogram{
+		schema:        schema,
+		zeroThreshold: zeroThreshold,
+		positive:      map[int32]uint64{},
+		negative:      map[int32]uint64{},
+		distribution:  distribution,
+	}, nil
+}
+
+// bucketIndex returns the index of the bucket the absolute value falls into,
+// bucket i covers (base^(i-1), base^i] with base = 2^(2^-schema)
+func (h *NativeHistogram) bucketIndex(value float64) int32 {
+	return int32(math.Ceil(math.Log2(value) * math.Exp2(float64(h.schema))))
+}
+
+func (h *NativeHistogram) Observe(n float64) error {
+	count, err := h.pending.take(n)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		value := h.distribution.Sample()
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			// there is no bucket for them
+			continue
+		}
+		if math.Abs(value) <= h.zeroThreshold {
+			h.zeroCount++
+		} else if value > 0 {
+			h.positive[h.bucketIndex(value)]++
+		} else {
+			h.negative[h.bucketIndex(-value)]++
+		}
+		h.sum += value
+		h.count++
+		h.last = &value
+	}
+	return nil
+}
+
+func (h *NativeHistogram) LastObservation() (int, float64, bool) {
+	if h.last == nil {
+		return 0, 0, false
+	}
+	value := *h.last
+	h.last = nil
+	return 0, value, true
+}
+
+func (h *NativeHistogram) Series(labels []*prometheus.Label) []*prometheus.TimeSeries {
+	return []*prometheus.TimeSeries{
+		{
+			Labels: withSuffix(labels, ""),
+		},
+	}
+}
+
+func (h *NativeHistogram) Append(series []*prometheus.TimeSeries, timestamp int64) {
+	positiveSpans, positiveDeltas := encodeBuckets(h.positive)
+	negativeSpans, negativeDeltas := encodeBuckets(h.negative)
+	series[0].Histograms = append(series[0].Histograms, &prometheus.Histogram{
+		Count:          &prometheus.Histogram_CountInt{CountInt: h.count},
+		Sum:            h.sum,
+		Schema:         h.schema,
+		ZeroThreshold:  h.zeroThreshold,
+		ZeroCount:      &prometheus.Histogram_ZeroCountInt{ZeroCountInt: h.zeroCount},
+		NegativeSpans:  negativeSpans,
+		NegativeDeltas: negativeDeltas,
+		PositiveSpans:  positiveSpans,
+		PositiveDeltas: positiveDeltas,
+		Timestamp:      timestamp,
+	})
+}
+
+// encodeBuckets converts bucket counts into spans of consecutive buckets and
+// the deltas between the counts of neighbouring buckets
+func encodeBuckets(buckets map[int32]uint64) ([]*prometheus.BucketSpan, []int64) {
+	var indexes []int
+	for index := range buckets {
+		indexes = append(indexes, int(index))
+	}
+	sort.Ints(indexes)
+
+	var spans []*prometheus.BucketSpan
+	var deltas []int64
+	var previousCount int64
+	for i, index := range indexes {
+		if i == 0 {
+			spans = append(spans, &prometheus.BucketSpan{Offset: int32(index)})
+		} else if gap := index - indexes[i-1] - 1; gap > 0 {
+			spans = append(spans, &prometheus.BucketSpan{Offset: int32(gap)})
+		}
+		spans[len(spans)-1].Length++
+
+		count := int64(buckets[int32(index)])
+		deltas = append(deltas, count-previousCount)
+		previousCount = count
+	}
+	return spans, deltas
+}
@@ -0,0 +1,66 @@
+package observation
+
+import (
+	"math"
+	"testing"
+)
+
+type fixed []float64
+
+func (f *fixed) Sample() float64 {
+	value := (*f)[0]
+	*f = (*f)[1:]
+	return value
+}
+
+func TestNativeHistogramSkipsNonFinite(t *testing.T) {
+	distribution := &fixed{math.NaN(), math.Inf(1), 2, math.Inf(-1), 0}
+	h, err := NewNativeHistogram(0, 0.001, distribution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Observe(5)
+	if h.count != 2 || h.sum != 2 || h.zeroCount != 1 || h.positive[1] != 1 || len(h.negative) != 0 {
+		t.Errorf("unexpected state: count %v, sum %v, zero %v, positive %v, negative %v", h.count, h.sum, h.zeroCount, h.positive, h.negative)
+	}
+}
+
+func TestClassicHistogramSkipsNaN(t *testing.T) {
+	distribution := &fixed{math.NaN(), 0.5, math.Inf(1)}
+	h, err := NewClassicHistogram([]float64{1}, distribution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Observe(3)
+	if h.count != 2 || h.counts[0] != 1 {
+		t.Errorf("unexpected state: count %v, buckets %v", h.count, h.counts)
+	}
+}
+
+func TestObserveFractions(t *testing.T) {
+	distribution := &fixed{1, 2, 3}
+	h, err := NewClassicHistogram([]float64{1}, distribution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []uint64{0, 1, 1, 3} {
+		if err := h.Observe([]float64{0.5, 0.5, 0.25, 1.75}[i]); err != nil {
+			t.Fatal(err)
+		}
+		if h.count != expected {
+			t.Errorf("expected %v observations after %v calls, got %v", expected, i+1, h.count)
+		}
+	}
+}
+
+func TestObserveInvalid(t *testing.T) {
+	h, err := NewClassicHistogram([]float64{1}, &fixed{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []float64{math.Inf(1), math.Inf(-1), math.NaN(), -1, MaxObservations + 1} {
+		if err := h.Observe(n); err == nil {
+			t.Errorf("expected an error for %v observations", n)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package observation
+
+import (
+	"errors"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+	"sort"
+)
+
+// Summary calculates quantiles over the observations of the most recent
+// intervals and derives the quantile, _sum and _count series of a summary
+type Summary struct {
+	quantiles    []float64
+	window       int
+	batches      [][]float64
+	current      []float64
+	sum          float64
+	count        uint64
+	distribution Distribution
+	pending      pending
+}
+
+func NewSummary(quantiles []float64, window int, distribution Distribution) (*Summary, error) {
+	if len(quantiles) == 0 {
+		return nil, errors.New("summary requires at least one quantile")
+	}
+
+	for _, quantile := range quantiles {
+		if quantile < 0 || quantile > 1 {
+			return nil, errors.New("summary quantiles must be between 0 and 1")
+		}
+	}
+
+	if window < 1 {
+		window = 1
+	}
+
+	return &Summary{
+		quantiles:    quantiles,
+		window:       window,
+		distribution: distribution,
+	}, nil
+}
+
+func (s *Summary) Observe(n float64) error {
+	count, err := s.pending.take(n)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		value := s.distribution.Sample()
+		if math.IsNaN(value) {
+			continue
+		}
+		s.current = append(s.current, value)
+		s.sum += value
+		s.count++
+	}
+	return nil
+}
+
+func (s *Summary) Append(series []*prometheus.TimeSeries, timestamp int64) {
+	// every append completes an interval
+	s.batches = append(s.batches, s.current)
+	s.current = nil
+	if len(s.batches) > s.window {
+		s.batches = s.batches[len(s.batches)-s.window:]
+	}
+
+	var observations []float64
+	for _, batch := range s.batches {
+		observations = append(observations, batch...)
+	}
+	sort.Float64s(observations)
+
+	var values []float64
+	for _, quantile := range s.quantiles {
+		values = append(values, rank(observations, quantile))
+	}
+	values = append(values, s.sum, float64(s.count))
+	appendSamples(series, values, timestamp)
+}
+
+func (s *Summary) Series(labels []*prometheus.Label) []*prometheus.TimeSeries {
+	var series []*prometheus.TimeSeries
+	for _, quantile := range s.quantiles {
+		quantileLabels := withSuffix(labels, "")
+		quantileLabels = append(quantileLabels, &prometheus.Label{
+			Name:  "quantile",
+			Value: formatFloat(quantile),
+		})
+		series = append(series, &prometheus.TimeSeries{
+			Labels: quantileLabels,
+		})
+	}
+	series = append(series, &prometheus.TimeSeries{
+		Labels: withSuffix(labels, "_sum"),
+	})
+	series = append(series, &prometheus.TimeSeries{
+		Labels: withSuffix(labels, "_count"),
+	})
+	return series
+}
+
+// rank returns the quantile of the sorted observations using the nearest
+// rank method, or NaN without observations like the Prometheus clients do
+func rank(sorted []float64, quantile float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	index := int(math.Ceil(quantile*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
@@ -0,0 +1,507 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"write/progression"
+)
+
+const (
+	ScopeName = "write"
+
+	// flagNoRecordedValue marks a data point without a value, the OTLP
+	// equivalent of a staleness marker
+	flagNoRecordedValue = uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)
+	cumulative          = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+)
+
+// semanticConventions maps Prometheus target labels to the OpenTelemetry
+// semantic conventions
+var semanticConventions = map[string]string{
+	"job":      "service.name",
+	"instance": "service.instance.id",
+}
+
+// Converter converts remote write requests into OTLP requests
+type Converter struct {
+	// ResourceLabels are the labels that become resource attributes, all
+	// others become data point attributes
+	ResourceLabels []string
+}
+
+func NewConverter(resourceLabels []string) *Converter {
+	return &Converter{
+		ResourceLabels: resourceLabels,
+	}
+}
+
+func (c *Converter) isResourceLabel(name string) bool {
+	for _, resourceLabel := range c.ResourceLabels {
+		if resourceLabel == name {
+			return true
+		}
+	}
+	return false
+}
+
+// split separates the labels of a series into its metric name, resource
+// attributes and data point attributes. The label named by skip, e.g. le,
+// is dropped.
+func (c *Converter) split(labels []*prometheus.Label, skip string) (string, []*commonpb.KeyValue, []*commonpb.KeyValue) {
+	name := ""
+	var resource, attributes []*commonpb.KeyValue
+	for _, label := range labels {
+		switch {
+		case label.Name == "__name__":
+			name = label.Value
+		case label.Name == skip:
+			continue
+		case c.isResourceLabel(label.Name):
+			key := label.Name
+			if mapped, ok := semanticConventions[key]; ok {
+				key = mapped
+			}
+			resource = append(resource, keyValue(key, label.Value))
+		default:
+			attributes = append(attributes, keyValue(label.Name, label.Value))
+		}
+	}
+	sort.Slice(resource, func(i, j int) bool {
+		return resource[i].Key < resource[j].Key
+	})
+	sort.Slice(attributes, func(i, j int) bool {
+		return attributes[i].Key < attributes[j].Key
+	})
+	return name, resource, attributes
+}
+
+func keyValue(key string, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+func labelValue(labels []*prometheus.Label, name string) string {
+	for _, label := range labels {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+func attributesKey(attributes []*commonpb.KeyValue) string {
+	var pairs []string
+	for _, attribute := range attributes {
+		pairs = append(pairs, attribute.Key+"=\""+attribute.Value.GetStringValue()+"\"")
+	}
+	return strings.Join(pairs, ",")
+}
+
+func nanos(milliseconds int64) uint64 {
+	return uint64(milliseconds * 1000000)
+}
+
+func isStale(value float64) bool {
+	return math.Float64bits(value) == math.Float64bits(progression.StaleNaN)
+}
+
+func family(name string, metadata map[string]*prometheus.MetricMetadata) (string, *prometheus.MetricMetadata) {
+	if m, ok := metadata[name]; ok {
+		return name, m
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if m, ok := metadata[strings.TrimSuffix(name, suffix)]; ok && strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), m
+		}
+	}
+	return name, nil
+}
+
+// parts of a classic histogram or summary data point, a data point is only
+// complete with all of them
+const (
+	partBuckets = 1 << iota
+	partSum
+	partCount
+
+	histogramParts = partBuckets | partSum | partCount
+	summaryParts   = partSum | partCount
+)
+
+// builder collects the metrics of a request grouped by resource
+type builder struct {
+	resources map[string]*metricspb.ResourceMetrics
+	metrics   map[string]*metricspb.Metric
+	order     []string
+}
+
+func (b *builder) metric(resource []*commonpb.KeyValue, name string, metadata *prometheus.MetricMetadata) *metricspb.Metric {
+	resourceKey := attributesKey(resource)
+	rm, ok := b.resources[resourceKey]
+	if !ok {
+		rm = &metricspb.ResourceMetrics{
+			Resource: &resourcepb.Resource{Attributes: resource},
+			ScopeMetrics: []*metricspb.ScopeMetrics{
+				{Scope: &commonpb.InstrumentationScope{Name: ScopeName}},
+			},
+		}
+		b.resources[resourceKey] = rm
+		b.order = append(b.order, resourceKey)
+	}
+
+	metricKey := resourceKey + "|" + name
+	metric, ok := b.metrics[metricKey]
+	if !ok {
+		metric = &metricspb.Metric{Name: name}
+		if metadata != nil {
+			metric.Description = metadata.Help
+			metric.Unit = metadata.Unit
+		}
+		b.metrics[metricKey] = metric
+		rm.ScopeMetrics[0].Metrics = append(rm.ScopeMetrics[0].Metrics, metric)
+	}
+	return metric
+}
+
+// Convert converts a remote write request into an OTLP request. Gauges and
+// series without metadata become gauges, counters become monotonic sums.
+// The series of classic histograms and summaries are combined into
+// histogram and summary data points, native histograms become exponential
+// histograms. The start time of cumulative data points is the created
+// timestamp of their series, created holds one for each series.
+//
+// Histogram and summary data points are only complete if the request holds
+// all of their series: the +Inf bucket, _sum and _count. Incomplete data
+// points are dropped, their number is returned.
+func (c *Converter) Convert(wr *prometheus.WriteRequest, created []int64) (*colmetricspb.ExportMetricsServiceRequest, int) {
+	metadata := map[string]*prometheus.MetricMetadata{}
+	for _, m := range wr.Metadata {
+		metadata[m.MetricFamilyName] = m
+	}
+
+	b := &builder{
+		resources: map[string]*metricspb.ResourceMetrics{},
+		metrics:   map[string]*metricspb.Metric{},
+	}
+
+	// series of classic histograms and summaries are collected first and
+	// combined per attribute set and timestamp
+	histograms := map[string]map[int64]*metricspb.HistogramDataPoint{}
+	histogramBounds := map[string]map[int64]map[float64]float64{}
+	summaries := map[string]map[int64]*metricspb.SummaryDataPoint{}
+	parts := map[string]map[int64]int{}
+	var pointKeys []string
+	metrics := map[string]*metricspb.Metric{}
+
+	for i, ts := range wr.Timeseries {
+		start := nanos(created[i])
+		name, familyMetadata := family(labelValue(ts.Labels, "__name__"), metadata)
+		metricType := prometheus.MetricMetadata_UNKNOWN
+		if familyMetadata != nil {
+			metricType = familyMetadata.Type
+		}
+
+		if len(ts.Histograms) > 0 {
+			_, resource, attributes := c.split(ts.Labels, "")
+			metric := b.metric(resource, name, familyMetadata)
+			if metric.GetExponentialHistogram() == nil {
+				metric.Data = &metricspb.Metric_ExponentialHistogram{
+					ExponentialHistogram: &metricspb.ExponentialHistogram{AggregationTemporality: cumulative},
+				}
+			}
+			histogram := metric.GetExponentialHistogram()
+			for _, h := range ts.Histograms {
+				histogram.DataPoints = append(histogram.DataPoints, exponentialDataPoint(h, attributes, start))
+			}
+			continue
+		}
+
+		switch metricType {
+		case prometheus.MetricMetadata_HISTOGRAM, prometheus.MetricMetadata_SUMMARY:
+			seriesName, resource, attributes := c.split(ts.Labels, "le")
+			if metricType == prometheus.MetricMetadata_SUMMARY {
+				_, resource, attributes = c.split(ts.Labels, "quantile")
+			}
+			metric := b.metric(resource, name, familyMetadata)
+			key := attributesKey(resource) + "|" + name + "|" + attributesKey(attributes)
+			if _, ok := metrics[key]; !ok {
+				metrics[key] = metric
+				pointKeys = append(pointKeys, key)
+				histograms[key] = map[int64]*metricspb.HistogramDataPoint{}
+				histogramBounds[key] = map[int64]map[float64]float64{}
+				summaries[key] = map[int64]*metricspb.SummaryDataPoint{}
+				parts[key] = map[int64]int{}
+			}
+
+			for _, sample := range ts.Samples {
+				if metricType == prometheus.MetricMetadata_HISTOGRAM {
+					point, ok := histograms[key][sample.Timestamp]
+					if !ok {
+						point = &metricspb.HistogramDataPoint{
+							Attributes:        attributes,
+							StartTimeUnixNano: start,
+							TimeUnixNano:      nanos(sample.Timestamp),
+						}
+						histograms[key][sample.Timestamp] = point
+						histogramBounds[key][sample.Timestamp] = map[float64]float64{}
+					}
+					for _, exemplar := range ts.Exemplars {
+						if exemplar.Timestamp == sample.Timestamp {
+							point.Exemplars = append(point.Exemplars, convertExemplar(exemplar))
+						}
+					}
+					if isStale(sample.Value) {
+						point.Flags = flagNoRecordedValue
+						continue
+					}
+					switch {
+					case strings.HasSuffix(seriesName, "_bucket"):
+						bound, err := strconv.ParseFloat(labelValue(ts.Labels, "le"), 64)
+						if err == nil {
+							histogramBounds[key][sample.Timestamp][bound] = sample.Value
+							if math.IsInf(bound, 1) {
+								parts[key][sample.Timestamp] |= partBuckets
+							}
+						}
+					case strings.HasSuffix(seriesName, "_sum"):
+						sum := sample.Value
+						point.Sum = &sum
+						parts[key][sample.Timestamp] |= partSum
+					case strings.HasSuffix(seriesName, "_count"):
+						point.Count = uint64(sample.Value)
+						parts[key][sample.Timestamp] |= partCount
+					}
+				} else {
+					point, ok := summaries[key][sample.Timestamp]
+					if !ok {
+						point = &metricspb.SummaryDataPoint{
+							Attributes:        attributes,
+							StartTimeUnixNano: start,
+							TimeUnixNano:      nanos(sample.Timestamp),
+						}
+						summaries[key][sample.Timestamp] = point
+					}
+					if isStale(sample.Value) {
+						point.Flags = flagNoRecordedValue
+						continue
+					}
+					switch {
+					case strings.HasSuffix(seriesName, "_sum"):
+						point.Sum = sample.Value
+						parts[key][sample.Timestamp] |= partSum
+					case strings.HasSuffix(seriesName, "_count"):
+						point.Count = uint64(sample.Value)
+						parts[key][sample.Timestamp] |= partCount
+					default:
+						quantile, err := strconv.ParseFloat(labelValue(ts.Labels, "quantile"), 64)
+						if err == nil {
+							point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+								Quantile: quantile,
+								Value:    sample.Value,
+							})
+						}
+					}
+				}
+			}
+		default:
+			_, resource, attributes := c.split(ts.Labels, "")
+			metric := b.metric(resource, name, familyMetadata)
+			var points *[]*metricspb.NumberDataPoint
+			if metricType == prometheus.MetricMetadata_COUNTER {
+				if metric.GetSum() == nil {
+					metric.Data = &metricspb.Metric_Sum{
+						Sum: &metricspb.Sum{
+							AggregationTemporality: cumulative,
+							IsMonotonic:            true,
+						},
+					}
+				}
+				points = &metric.GetSum().DataPoints
+			} else {
+				if metric.GetGauge() == nil {
+					metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+				}
+				points = &metric.GetGauge().DataPoints
+				start = 0
+			}
+
+			for _, sample := range ts.Samples {
+				point := &metricspb.NumberDataPoint{
+					Attributes:        attributes,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      nanos(sample.Timestamp),
+				}
+				if isStale(sample.Value) {
+					point.Flags = flagNoRecordedValue
+				} else {
+					point.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: sample.Value}
+				}
+				for _, exemplar := range ts.Exemplars {
+					if exemplar.Timestamp == sample.Timestamp {
+						point.Exemplars = append(point.Exemplars, convertExemplar(exemplar))
+					}
+				}
+				*points = append(*points, point)
+			}
+		}
+	}
+
+	dropped := 0
+	for _, key := range pointKeys {
+		metric := metrics[key]
+		for _, timestamp := range sortedTimestamps(histograms[key]) {
+			point := histograms[key][timestamp]
+			if point.Flags == 0 && parts[key][timestamp] != histogramParts {
+				dropped++
+				continue
+			}
+			if metric.GetHistogram() == nil {
+				metric.Data = &metricspb.Metric_Histogram{
+					Histogram: &metricspb.Histogram{AggregationTemporality: cumulative},
+				}
+			}
+			if point.Flags == 0 {
+				point.ExplicitBounds, point.BucketCounts = bucketCounts(histogramBounds[key][timestamp])
+			}
+			histogram := metric.GetHistogram()
+			histogram.DataPoints = append(histogram.DataPoints, point)
+		}
+		for _, timestamp := range sortedTimestamps(summaries[key]) {
+			point := summaries[key][timestamp]
+			if point.Flags == 0 && parts[key][timestamp] != summaryParts {
+				dropped++
+				continue
+			}
+			if metric.GetSummary() == nil {
+				metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{}}
+			}
+			sort.Slice(point.QuantileValues, func(i, j int) bool {
+				return point.QuantileValues[i].Quantile < point.QuantileValues[j].Quantile
+			})
+			summary := metric.GetSummary()
+			summary.DataPoints = append(summary.DataPoints, point)
+		}
+	}
+
+	request := &colmetricspb.ExportMetricsServiceRequest{}
+	for _, key := range b.order {
+		request.ResourceMetrics = append(request.ResourceMetrics, b.resources[key])
+	}
+	return request, dropped
+}
+
+func sortedTimestamps[T any](points map[int64]T) []int64 {
+	var timestamps []int64
+	for timestamp := range points {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+	return timestamps
+}
+
+// bucketCounts converts the cumulative counts of classic histogram buckets
+// into the explicit bounds and the per bucket counts of OTLP
+func bucketCounts(buckets map[float64]float64) ([]float64, []uint64) {
+	var bounds []float64
+	for bound := range buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+
+	var explicitBounds []float64
+	var counts []uint64
+	previous := 0.0
+	for _, bound := range bounds {
+		if !math.IsInf(bound, 1) {
+			explicitBounds = append(explicitBounds, bound)
+		}
+		counts = append(counts, uint64(buckets[bound]-previous))
+		previous = buckets[bound]
+	}
+	return explicitBounds, counts
+}
+
+// exponentialDataPoint converts a native histogram. Prometheus bucket i
+// covers (base^(i-1), base^i], OTLP bucket i covers (base^i, base^(i+1)].
+func exponentialDataPoint(h *prometheus.Histogram, attributes []*commonpb.KeyValue, start uint64) *metricspb.ExponentialHistogramDataPoint {
+	point := &metricspb.ExponentialHistogramDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: start,
+		TimeUnixNano:      nanos(h.Timestamp),
+		Scale:             h.Schema,
+		ZeroThreshold:     h.ZeroThreshold,
+	}
+
+	if isStale(h.Sum) {
+		point.Flags = flagNoRecordedValue
+		return point
+	}
+
+	sum := h.Sum
+	point.Sum = &sum
+	if count, ok := h.Count.(*prometheus.Histogram_CountInt); ok {
+		point.Count = count.CountInt
+	}
+	if zeroCount, ok := h.ZeroCount.(*prometheus.Histogram_ZeroCountInt); ok {
+		point.ZeroCount = zeroCount.ZeroCountInt
+	}
+	point.Positive = exponentialBuckets(h.PositiveSpans, h.PositiveDeltas)
+	point.Negative = exponentialBuckets(h.NegativeSpans, h.NegativeDeltas)
+	return point
+}
+
+func exponentialBuckets(spans []*prometheus.BucketSpan, deltas []int64) *metricspb.ExponentialHistogramDataPoint_Buckets {
+	buckets := &metricspb.ExponentialHistogramDataPoint_Buckets{}
+	if len(spans) == 0 {
+		return buckets
+	}
+
+	buckets.Offset = spans[0].Offset - 1
+	var count int64
+	position := 0
+	for i, span := range spans {
+		if i > 0 {
+			// gaps between spans are empty buckets
+			for j := int32(0); j < span.Offset; j++ {
+				buckets.BucketCounts = append(buckets.BucketCounts, 0)
+			}
+		}
+		for j := uint32(0); j < span.Length && position < len(deltas); j++ {
+			count += deltas[position]
+			position++
+			buckets.BucketCounts = append(buckets.BucketCounts, uint64(count))
+		}
+	}
+	return buckets
+}
+
+func convertExemplar(exemplar *prometheus.Exemplar) *metricspb.Exemplar {
+	converted := &metricspb.Exemplar{
+		TimeUnixNano: nanos(exemplar.Timestamp),
+		Value:        &metricspb.Exemplar_AsDouble{AsDouble: exemplar.Value},
+	}
+	for _, label := range exemplar.Labels {
+		id, err := hex.DecodeString(label.Value)
+		switch {
+		case label.Name == "trace_id" && len(id) == 16 && err == nil:
+			converted.TraceId = id
+		case label.Name == "span_id" && len(id) == 8 && err == nil:
+			converted.SpanId = id
+		default:
+			converted.FilteredAttributes = append(converted.FilteredAttributes, keyValue(label.Name, label.Value))
+		}
+	}
+	return converted
+}
@@ -0,0 +1,236 @@
+package otlp
+
+import (
+	"encoding/json"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func series(name string, le string, value float64) *prometheus.TimeSeries {
+	labels := []*prometheus.Label{{Name: "__name__", Value: name}, {Name: "job", Value: "api"}}
+	if le != "" {
+		labels = append(labels, &prometheus.Label{Name: "le", Value: le})
+	}
+	return &prometheus.TimeSeries{
+		Labels:  labels,
+		Samples: []*prometheus.Sample{{Value: value, Timestamp: 1000}},
+	}
+}
+
+// decode converts the request, encodes it as protobuf and decodes the
+// metrics again
+func decode(t *testing.T, wr *prometheus.WriteRequest) (map[string]*metricsv1.Metric, int) {
+	created := make([]int64, len(wr.Timeseries))
+	request, dropped := NewConverter([]string{"job"}).Convert(wr, created)
+	encoded, contentType, err := Marshal(request, ProtocolProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/x-protobuf" {
+		t.Errorf("unexpected content type %v", contentType)
+	}
+	data := &colmetricspb.ExportMetricsServiceRequest{}
+	err = proto.Unmarshal(encoded, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]*metricsv1.Metric{}
+	for _, rm := range data.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, metric := range sm.Metrics {
+				metrics[metric.Name] = metric
+			}
+		}
+	}
+	return metrics, dropped
+}
+
+func TestConvertClassicHistogram(t *testing.T) {
+	wr := &prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			series("latency_bucket", "0.5", 1),
+			series("latency_bucket", "+Inf", 3),
+			series("latency_bucket", "2", 2),
+			series("latency_sum", "", 4.5),
+			series("latency_count", "", 3),
+		},
+		Metadata: []*prometheus.MetricMetadata{
+			{MetricFamilyName: "latency", Type: prometheus.MetricMetadata_HISTOGRAM},
+		},
+	}
+
+	metrics, dropped := decode(t, wr)
+	if dropped != 0 {
+		t.Errorf("expected no dropped points, got %v", dropped)
+	}
+	points := metrics["latency"].GetHistogram().GetDataPoints()
+	if len(points) != 1 {
+		t.Fatalf("expected one data point, got %v", points)
+	}
+	point := points[0]
+	if !reflect.DeepEqual(point.ExplicitBounds, []float64{0.5, 2}) || !reflect.DeepEqual(point.BucketCounts, []uint64{1, 1, 1}) {
+		t.Errorf("unexpected buckets: bounds %v, counts %v", point.ExplicitBounds, point.BucketCounts)
+	}
+	if point.GetSum() != 4.5 || point.Count != 3 || point.TimeUnixNano != 1000000000 {
+		t.Errorf("unexpected data point %v", point)
+	}
+}
+
+func TestConvertDropsIncomplete(t *testing.T) {
+	wr := &prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			series("latency_count", "", 3),
+			series("latency_sum", "", 4.5),
+			series("size_bucket", "1", 1),
+			series("size_sum", "", 1),
+			series("size_count", "", 1),
+			series("rpc_count", "", 2),
+		},
+		Metadata: []*prometheus.MetricMetadata{
+			{MetricFamilyName: "latency", Type: prometheus.MetricMetadata_HISTOGRAM},
+			{MetricFamilyName: "size", Type: prometheus.MetricMetadata_HISTOGRAM},
+			{MetricFamilyName: "rpc", Type: prometheus.MetricMetadata_SUMMARY},
+		},
+	}
+
+	metrics, dropped := decode(t, wr)
+	if dropped != 3 {
+		t.Errorf("expected 3 dropped points, got %v", dropped)
+	}
+	for name, metric := range metrics {
+		if len(metric.GetHistogram().GetDataPoints()) > 0 || len(metric.GetSummary().GetDataPoints()) > 0 {
+			t.Errorf("expected no data points for %v, got %v", name, metric)
+		}
+	}
+}
+
+func TestConvertStaleHistogram(t *testing.T) {
+	stale := math.Float64frombits(0x7ff0000000000002)
+	wr := &prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			series("latency_count", "", stale),
+		},
+		Metadata: []*prometheus.MetricMetadata{
+			{MetricFamilyName: "latency", Type: prometheus.MetricMetadata_HISTOGRAM},
+		},
+	}
+
+	metrics, dropped := decode(t, wr)
+	points := metrics["latency"].GetHistogram().GetDataPoints()
+	if dropped != 0 || len(points) != 1 || points[0].Flags != uint32(metricsv1.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) {
+		t.Errorf("expected a data point without recorded value, got %v, %v dropped", points, dropped)
+	}
+}
+
+func TestConvertNativeHistogram(t *testing.T) {
+	wr := &prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			{
+				Labels: []*prometheus.Label{{Name: "__name__", Value: "latency"}, {Name: "job", Value: "api"}},
+				Histograms: []*prometheus.Histogram{
+					{
+						Count:          &prometheus.Histogram_CountInt{CountInt: 8},
+						Sum:            20,
+						Schema:         0,
+						ZeroThreshold:  0.001,
+						ZeroCount:      &prometheus.Histogram_ZeroCountInt{ZeroCountInt: 1},
+						NegativeSpans:  []*prometheus.BucketSpan{{Offset: 1, Length: 1}},
+						NegativeDeltas: []int64{1},
+						PositiveSpans:  []*prometheus.BucketSpan{{Offset: 1, Length: 2}, {Offset: 1, Length: 1}},
+						PositiveDeltas: []int64{2, 1, -2},
+						Timestamp:      1000,
+					},
+				},
+			},
+		},
+	}
+
+	metrics, _ := decode(t, wr)
+	points := metrics["latency"].GetExponentialHistogram().GetDataPoints()
+	if len(points) != 1 {
+		t.Fatalf("expected one data point, got %v", points)
+	}
+	point := points[0]
+	if point.Scale != 0 || point.Count != 8 || point.GetSum() != 20 || point.ZeroCount != 1 || point.ZeroThreshold != 0.001 {
+		t.Errorf("unexpected data point %v", point)
+	}
+
+	// Prometheus bucket 1 covers (1, 2], at scale 0 the OTLP bucket covering
+	// it has index 0
+	if point.Positive.Offset != 0 || !reflect.DeepEqual(point.Positive.BucketCounts, []uint64{2, 3, 0, 1}) {
+		t.Errorf("unexpected positive buckets %v", point.Positive)
+	}
+	if point.Negative.Offset != 0 || !reflect.DeepEqual(point.Negative.BucketCounts, []uint64{1}) {
+		t.Errorf("unexpected negative buckets %v", point.Negative)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	wr := &prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			{
+				Labels:  []*prometheus.Label{{Name: "__name__", Value: "requests_total"}, {Name: "job", Value: "api"}},
+				Samples: []*prometheus.Sample{{Value: math.Inf(1), Timestamp: 1000}},
+				Exemplars: []*prometheus.Exemplar{
+					{
+						Labels:    []*prometheus.Label{{Name: "trace_id", Value: "4bf92f3577b34da6a3ce929d0e0e4736"}, {Name: "span_id", Value: "00f067aa0ba902b7"}},
+						Value:     1,
+						Timestamp: 1000,
+					},
+				},
+			},
+		},
+		Metadata: []*prometheus.MetricMetadata{
+			{MetricFamilyName: "requests_total", Type: prometheus.MetricMetadata_COUNTER},
+		},
+	}
+	request, _ := NewConverter([]string{"job"}).Convert(wr, []int64{500})
+	data, contentType, err := Marshal(request, ProtocolJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/json" {
+		t.Errorf("unexpected content type %v", contentType)
+	}
+
+	var decoded struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Sum struct {
+						AggregationTemporality int  `json:"aggregationTemporality"`
+						IsMonotonic            bool `json:"isMonotonic"`
+						DataPoints             []struct {
+							StartTimeUnixNano string      `json:"startTimeUnixNano"`
+							AsDouble          interface{} `json:"asDouble"`
+							Exemplars         []struct {
+								TraceId string `json:"traceId"`
+								SpanId  string `json:"spanId"`
+							} `json:"exemplars"`
+						} `json:"dataPoints"`
+					} `json:"sum"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := decoded.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Sum
+	if sum.AggregationTemporality != 2 || !sum.IsMonotonic {
+		t.Errorf("unexpected sum in %s", data)
+	}
+	point := sum.DataPoints[0]
+	if point.StartTimeUnixNano != "500000000" || point.AsDouble != "Infinity" {
+		t.Errorf("unexpected data point in %s", data)
+	}
+	if point.Exemplars[0].TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || point.Exemplars[0].SpanId != "00f067aa0ba902b7" {
+		t.Errorf("expected hex trace and span IDs in %s", data)
+	}
+}
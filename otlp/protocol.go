@@ -0,0 +1,86 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ProtocolProtobuf = "http/protobuf"
+	ProtocolJSON     = "http/json"
+)
+
+// IsValidProtocol returns true if the protocol is one of the supported
+// OTLP/HTTP encodings
+func IsValidProtocol(protocol string) bool {
+	return protocol == ProtocolProtobuf || protocol == ProtocolJSON
+}
+
+// Marshal encodes the request for an OTLP/HTTP endpoint and returns the
+// content type of the encoding
+func Marshal(request *colmetricspb.ExportMetricsServiceRequest, protocol string) ([]byte, string, error) {
+	if protocol == ProtocolJSON {
+		data, err := marshalJSON(request)
+		return data, "application/json", err
+	}
+	data, err := proto.Marshal(request)
+	return data, "application/x-protobuf", err
+}
+
+// marshalJSON encodes the request in OTLP/JSON, which differs from the
+// canonical protobuf JSON mapping: enums are encoded as numbers and trace
+// and span IDs as hex instead of base64
+func marshalJSON(request *colmetricspb.ExportMetricsServiceRequest) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err = decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	err = hexIDs(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// hexIDs re-encodes the base64 traceId and spanId fields of exemplars as hex
+func hexIDs(value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			id, ok := field.(string)
+			if ok && (key == "traceId" || key == "spanId") {
+				decoded, err := base64.StdEncoding.DecodeString(id)
+				if err != nil {
+					return err
+				}
+				v[key] = hex.EncodeToString(decoded)
+				continue
+			}
+			err := hexIDs(field)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			err := hexIDs(item)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package progression
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -47,7 +49,57 @@ func (p *ProgressionParser) expect(t TokenType) (*Token, error) {
 		return token, nil
 	}
 
-	return nil, errors.New("unexpected token: " + token.StringVal)
+	return nil, errors.New(fmt.Sprintf("unexpected token, expected %v but got %v:%v", TokenMapping[t], TokenMapping[token.TokenType], token.StringVal))
+}
+
+func (p *ProgressionParser) accept(t TokenType) bool {
+	if !p.hasTokens() {
+		return false
+	}
+	if p.tokens.at(p.index).TokenType == t {
+		p.index = p.index + 1
+		return true
+	}
+	return false
+}
+
+// value parses a number with an optional sign, e.g. -1 or +Inf
+func (p *ProgressionParser) value() (float64, error) {
+	token, err := p.peek()
+	if err != nil {
+		return 0, err
+	}
+
+	sign := 1.0
+	if token.TokenType == TokenTypePlusMinus {
+		p.index = p.index + 1
+		if token.StringVal == "-" {
+			sign = -1.0
+		}
+	}
+
+	value, err := p.expect(TokenTypeValue)
+	if err != nil {
+		return 0, err
+	}
+	return sign * value.FloatVal, nil
+}
+
+// times parses the optional x<Times> suffix of a series item
+func (p *ProgressionParser) times(defaultTimes float64) (float64, error) {
+	if !p.accept(TokenTypeX) {
+		return defaultTimes, nil
+	}
+	token, err := p.expect(TokenTypeValue)
+	if err != nil {
+		return 0, err
+	}
+	// like promtool, repetitions are a whole number
+	times, err := strconv.ParseUint(token.StringVal, 10, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid number of repetitions: %v", token.StringVal))
+	}
+	return float64(times), nil
 }
 
 func (p *ProgressionParser) nodata() (*Progression, error) {
@@ -60,35 +112,71 @@ func (p *ProgressionParser) nodata() (*Progression, error) {
 		return nil, err
 	}
 
-	_, err = p.expect(TokenTypeX)
+	// _ is a single missing sample, _x<Times> is <Times> missing samples
+	progression.Times, err = p.times(1)
 	if err != nil {
 		return nil, err
 	}
+	return &progression, nil
+}
 
-	token, err := p.expect(TokenTypeValue)
+func (p *ProgressionParser) stale() (*Progression, error) {
+	_, err := p.expect(TokenTypeStale)
 	if err != nil {
 		return nil, err
 	}
-	progression.Times = token.FloatVal
-	return &progression, nil
+
+	return &Progression{
+		Stale: true,
+		Times: 1,
+	}, nil
+}
+
+func (p *ProgressionParser) reset() (*Progression, error) {
+	_, err := p.expect(TokenTypeReset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Progression{
+		Reset: true,
+		Times: 1,
+	}, nil
 }
 
 func (p *ProgressionParser) progression() (*Progression, error) {
 	progression := Progression{
 		NoData: false,
 	}
-	token, err := p.expect(TokenTypeValue)
+	initial, err := p.value()
 	if err != nil {
 		return nil, err
 	}
-	progression.Initial = token.FloatVal
+	progression.Initial = initial
+
+	iv, err := p.peek()
+	if err != nil || iv.TokenType == TokenTypeSeparator {
+		// a literal value
+		progression.Times = 1
+		return &progression, nil
+	}
+
+	if iv.TokenType == TokenTypeX {
+		// <Initial>x<Times> repeats the initial value
+		times, err := p.times(0)
+		if err != nil {
+			return nil, err
+		}
+		progression.Times = times + 1
+		return &progression, nil
+	}
 
 	incrementType, err := p.expect(TokenTypePlusMinus)
 	if err != nil {
 		return nil, err
 	}
 
-	iv, err := p.peek()
+	iv, err = p.peek()
 	if err != nil {
 		return nil, err
 	}
@@ -114,23 +202,23 @@ func (p *ProgressionParser) progression() (*Progression, error) {
 		return nil, errors.New("unexpected increment value type")
 	}
 
-	p.expect(TokenTypeX)
-	iterations, err := p.expect(TokenTypeValue)
+	// like promtool, the initial value is included, so a+bxn yields n+1 samples
+	iterations, err := p.times(1)
 	if err != nil {
 		return nil, err
 	}
 
-	progression.Times = iterations.FloatVal
+	progression.Times = iterations + 1
 	return &progression, nil
 }
 
 func (p *ProgressionParser) ParseRealtime() (ProgressionProvider, error) {
 	rt := Realtime{}
-	token, err := p.expect(TokenTypeValue)
+	initial, err := p.value()
 	if err != nil {
 		return nil, err
 	}
-	rt.Initial = token.FloatVal
+	rt.Initial = initial
 
 	incrementType, err := p.expect(TokenTypePlusMinus)
 	if err != nil {
@@ -167,6 +255,9 @@ func (p *ProgressionParser) ParseRealtime() (ProgressionProvider, error) {
 }
 
 func (p *ProgressionParser) Parse(interval time.Duration) (ProgressionProvider, error) {
+	if !p.hasTokens() {
+		return nil, errors.New("empty series")
+	}
 	list := &ProgressionList{
 		interval:   interval,
 		iterations: 0,
@@ -177,18 +268,28 @@ func (p *ProgressionParser) Parse(interval time.Duration) (ProgressionProvider,
 			return nil, err
 		}
 
-		if nextToken.TokenType == TokenTypeUnderscore {
-			progression, err := p.nodata()
-			if err != nil {
-				return nil, err
-			}
-			list.progressions = append(list.progressions, progression)
-		} else {
-			progression, err := p.progression()
+		var progression *Progression
+		switch nextToken.TokenType {
+		case TokenTypeUnderscore:
+			progression, err = p.nodata()
+		case TokenTypeStale:
+			progression, err = p.stale()
+		case TokenTypeReset:
+			progression, err = p.reset()
+		default:
+			progression, err = p.progression()
+		}
+		if err != nil {
+			return nil, err
+		}
+		list.progressions = append(list.progressions, progression)
+
+		// series items are separated by whitespace
+		if p.hasTokens() {
+			_, err = p.expect(TokenTypeSeparator)
 			if err != nil {
 				return nil, err
 			}
-			list.progressions = append(list.progressions, progression)
 		}
 	}
 	list.startTimestamp = time.Now().UnixMilli() - (list.count() * list.interval.Milliseconds())
@@ -0,0 +1,143 @@
+package progression
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{"", "   ", "1x", "1+", "1+2x", "1+1x2.5", "1x1.5", "_x0.5", "1+1xInf", "1x1e3"} {
+		tokens, err := NewProgressionScanner().Scan(input)
+		if err != nil {
+			continue
+		}
+		_, err = NewProgressionParser(tokens).Parse(time.Second)
+		if err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestEmptyListNext(t *testing.T) {
+	list := &ProgressionList{}
+	valid, value, _ := list.Next()
+	if valid || value != nil {
+		t.Errorf("expected no values from an empty list, got %v %v", valid, value)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	tokens, err := NewProgressionScanner().Scan("1 2+1x2 _ 3x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := NewProgressionParser(tokens).Parse(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []float64
+	samples := 0
+	for {
+		valid, value, _ := list.Next()
+		if !valid {
+			break
+		}
+		samples++
+		if value != nil {
+			values = append(values, *value)
+		}
+	}
+	expected := []float64{1, 2, 3, 4, 3, 3}
+	if samples != len(expected)+1 || len(values) != len(expected) {
+		t.Fatalf("expected %v and one gap, got %v in %v samples", expected, values, samples)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, values)
+			break
+		}
+	}
+}
+
+func TestParseRealtimeWhitespace(t *testing.T) {
+	for _, input := range []string{"1+0", "1 + 0", " -1 +  2 ", "1 - (fn)"} {
+		tokens, err := NewProgressionScanner().ScanRealtime(input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		_, err = NewProgressionParser(tokens).ParseRealtime()
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+		}
+	}
+}
+
+func counterValues(t *testing.T, input string) ([]float64, error) {
+	tokens, err := NewProgressionScanner().Scan(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := NewProgressionParser(tokens).Parse(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.AsCounter()
+	var values []float64
+	for {
+		valid, value, _ := list.Next()
+		if !valid {
+			break
+		}
+		if value != nil {
+			values = append(values, *value)
+		}
+	}
+	return values, list.Err()
+}
+
+func TestCounter(t *testing.T) {
+	values, err := counterValues(t, "1+1x2 reset 0+2x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []float64{1, 2, 3, 0, 0, 2}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, values)
+			break
+		}
+	}
+}
+
+func TestCounterInvalid(t *testing.T) {
+	for _, input := range []string{"-1+1x2", "-Inf", "3 2", "1+1x2 1"} {
+		values, err := counterValues(t, input)
+		if err == nil {
+			t.Errorf("expected an error for %q, got %v", input, values)
+		}
+	}
+}
+
+func TestRealtimeCounterDecrease(t *testing.T) {
+	tokens, err := NewProgressionScanner().ScanRealtime("3-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewProgressionParser(tokens).ParseRealtime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.AsCounter()
+	valid, value, _ := rt.Next()
+	if !valid || *value != 3 {
+		t.Fatalf("expected 3, got %v %v", valid, value)
+	}
+	valid, _, _ = rt.Next()
+	if valid || rt.Err() == nil {
+		t.Errorf("expected an error after the counter decreased")
+	}
+}
@@ -1,13 +1,46 @@
 package progression
 
 import (
+	"errors"
+	"fmt"
 	"github.com/Shopify/go-lua"
+	"math"
 	"time"
 )
 
+// StaleNaN is the special NaN value Prometheus uses to mark a series as stale
+var StaleNaN = math.Float64frombits(0x7ff0000000000002)
+
 type ProgressionProvider interface {
 	Next() (bool, *float64, int64)
 	WithLuaState(state *lua.State)
+	AsCounter()
+	Err() error
+}
+
+// counter enforces counter semantics on a sequence of values: counters
+// are never negative and never decrease unless they are explicitly reset
+type counter struct {
+	enabled bool
+	last    float64
+}
+
+func (c *counter) next(val float64) error {
+	if !c.enabled || math.IsNaN(val) {
+		return nil
+	}
+	if val < 0 {
+		return errors.New(fmt.Sprintf("negative counter value %v", val))
+	}
+	if val < c.last {
+		return errors.New(fmt.Sprintf("counter decreased from %v to %v without reset", c.last, val))
+	}
+	c.last = val
+	return nil
+}
+
+func (c *counter) reset() {
+	c.last = 0
 }
 
 type Realtime struct {
@@ -16,10 +49,14 @@ type Realtime struct {
 	Increment    float64
 	Fn           string
 	luaState     *lua.State
+	counter      counter
+	err          error
 }
 
 type Progression struct {
 	NoData       bool
+	Stale        bool
+	Reset        bool
 	timesAlready float64
 	Initial      float64
 	Increment    float64
@@ -34,9 +71,16 @@ type ProgressionList struct {
 	startTimestamp int64
 	progressions   []*Progression
 	luaState       *lua.State
+	counter        counter
+	err            error
 }
 
+// Next returns the next value of the series, it returns false once the
+// series failed, e.g. when a counter decreased; the reason is in Err
 func (p *Realtime) Next() (bool, *float64, int64) {
+	if p.err != nil {
+		return false, nil, 0
+	}
 	var nextVal float64
 	if p.Fn != "" && p.luaState != nil {
 		p.luaState.Global(p.Fn)
@@ -50,6 +94,10 @@ func (p *Realtime) Next() (bool, *float64, int64) {
 	} else {
 		nextVal = p.Initial + (p.timesAlready * p.Increment)
 	}
+	if err := p.counter.next(nextVal); err != nil {
+		p.err = err
+		return false, nil, 0
+	}
 	p.timesAlready++
 	return true, &nextVal, time.Now().UnixMilli()
 }
@@ -58,34 +106,58 @@ func (p *Realtime) WithLuaState(state *lua.State) {
 	p.luaState = state
 }
 
+func (p *Realtime) AsCounter() {
+	p.counter.enabled = true
+}
+
+func (p *Realtime) Err() error {
+	return p.err
+}
+
 func (p *Progression) Next(luaState *lua.State) (bool, *float64) {
 	if p.timesAlready >= p.Times {
 		return false, nil
 	}
+	times := p.timesAlready
 	p.timesAlready += 1
 	var val float64
 	if p.NoData {
 		return true, nil
 	}
 
+	if p.Stale {
+		val = StaleNaN
+		return true, &val
+	}
+
+	if p.Reset {
+		return true, &val
+	}
+
 	if p.Fn != "" && luaState != nil {
 		luaState.Global(p.Fn)
 		luaState.PushNumber(p.Initial)
-		luaState.PushNumber(p.timesAlready)
+		luaState.PushNumber(times)
 		luaState.Call(2, 1)
 		lua.CheckNumber(luaState, luaState.Top())
 		val, _ = luaState.ToNumber(luaState.Top())
 		// empty stack
 		luaState.Pop(luaState.Top())
 	} else {
-		val = p.Initial + (p.timesAlready * p.Increment)
+		val = p.Initial + (times * p.Increment)
 	}
 
 	return true, &val
 
 }
 
+// Next returns the next value of the list, it returns false at the end of
+// the list or once the list failed, e.g. when a counter decreased without
+// reset; the reason is in Err
 func (p *ProgressionList) Next() (bool, *float64, int64) {
+	if len(p.progressions) == 0 || p.err != nil {
+		return false, nil, 0
+	}
 	valid, val := p.progressions[p.index].Next(p.luaState)
 	if !valid {
 		if p.index >= len(p.progressions)-1 {
@@ -94,6 +166,15 @@ func (p *ProgressionList) Next() (bool, *float64, int64) {
 		p.index += 1
 		return p.Next()
 	}
+	if val != nil {
+		if p.progressions[p.index].Reset {
+			p.counter.reset()
+		}
+		if err := p.counter.next(*val); err != nil {
+			p.err = err
+			return false, nil, 0
+		}
+	}
 	ts := p.startTimestamp + (p.iterations * p.interval.Milliseconds())
 	p.iterations++
 	return true, val, ts
@@ -110,3 +191,11 @@ func (p *ProgressionList) count() int64 {
 func (p *ProgressionList) WithLuaState(state *lua.State) {
 	p.luaState = state
 }
+
+func (p *ProgressionList) AsCounter() {
+	p.counter.enabled = true
+}
+
+func (p *ProgressionList) Err() error {
+	return p.err
+}
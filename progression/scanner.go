@@ -8,6 +8,11 @@ import (
 	"unicode"
 )
 
+var keywords = map[string]TokenType{
+	"stale": TokenTypeStale,
+	"reset": TokenTypeReset,
+}
+
 type Scanner struct {
 }
 
@@ -15,7 +20,19 @@ func NewProgressionScanner() *Scanner {
 	return &Scanner{}
 }
 
-func (*Scanner) Scan(data string) (TokenList, error) {
+// Scan splits a series into tokens, whitespace between its items becomes a
+// separator token
+func (s *Scanner) Scan(data string) (TokenList, error) {
+	return s.scan(data, true)
+}
+
+// ScanRealtime splits a realtime expression into tokens, whitespace is
+// ignored so that 1 + 0 is the same as 1+0
+func (s *Scanner) ScanRealtime(data string) (TokenList, error) {
+	return s.scan(data, false)
+}
+
+func (*Scanner) scan(data string, separators bool) (TokenList, error) {
 	var tokens TokenList
 	runes := []rune(data)
 	index := 0
@@ -33,32 +50,61 @@ func (*Scanner) Scan(data string) (TokenList, error) {
 		if currentNumber.Len() == 0 {
 			return nil
 		}
+		if keyword, ok := keywords[currentNumber.String()]; ok {
+			tokens = append(tokens, Token{
+				TokenType: keyword,
+				StringVal: currentNumber.String(),
+			})
+			currentNumber.Reset()
+			return nil
+		}
 		i, err := strconv.ParseFloat(currentNumber.String(), 64)
 		if err != nil {
 			return err
 		}
 		tokens = append(tokens, Token{
 			TokenType: TokenTypeValue,
+			StringVal: currentNumber.String(),
 			FloatVal:  i,
 		})
 		currentNumber.Reset()
 		return nil
 	}
 
+	// exponent returns true if the current number ends in an exponent marker,
+	// in which case a following sign belongs to the number, e.g. 1e-3
+	exponent := func() bool {
+		number := currentNumber.String()
+		if len(number) < 2 {
+			return false
+		}
+		last := number[len(number)-1]
+		return (last == 'e' || last == 'E') && (unicode.IsDigit(rune(number[0])) || number[0] == '.')
+	}
+
 	for index < len(runes) {
 		r := next()
 
-		// ignore whitespace
+		// whitespace separates the items of a series
 		if unicode.IsSpace(r) {
 			err := consumeNumber()
 			if err != nil {
 				return nil, err
 			}
+			if separators && len(tokens) > 0 && tokens[len(tokens)-1].TokenType != TokenTypeSeparator {
+				tokens = append(tokens, Token{
+					TokenType: TokenTypeSeparator,
+				})
+			}
 			continue
 		}
 
 		switch r {
 		case '+', '-':
+			if exponent() {
+				currentNumber.WriteRune(r)
+				continue
+			}
 			err := consumeNumber()
 			if err != nil {
 				return nil, err
@@ -119,5 +165,10 @@ func (*Scanner) Scan(data string) (TokenList, error) {
 		return nil, err
 	}
 
+	// drop a trailing separator
+	if len(tokens) > 0 && tokens[len(tokens)-1].TokenType == TokenTypeSeparator {
+		tokens = tokens[:len(tokens)-1]
+	}
+
 	return tokens, nil
 }
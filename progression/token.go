@@ -6,8 +6,22 @@ const (
 	TokenTypeX
 	TokenTypeUnderscore
 	TokenTypeFn
+	TokenTypeStale
+	TokenTypeSeparator
+	TokenTypeReset
 )
 
+var TokenMapping = map[TokenType]string{
+	TokenTypeValue:      "<value>",
+	TokenTypePlusMinus:  "+/-",
+	TokenTypeX:          "x",
+	TokenTypeUnderscore: "_",
+	TokenTypeFn:         "<function>",
+	TokenTypeStale:      "stale",
+	TokenTypeSeparator:  "<space>",
+	TokenTypeReset:      "reset",
+}
+
 type TokenType int
 
 type Token struct {
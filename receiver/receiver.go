@@ -0,0 +1,276 @@
+package receiver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"write/progression"
+	"write/remotewrite"
+)
+
+const (
+	WritePath  = "/api/v1/write"
+	SeriesPath = "/api/v1/series"
+)
+
+// Receiver accepts remote write requests and keeps all received series in
+// memory
+type Receiver struct {
+	lock     sync.Mutex
+	series   map[string]*prometheus.TimeSeries
+	metadata map[string]*prometheus.MetricMetadata
+}
+
+type Sample struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+type Histogram struct {
+	Timestamp int64  `json:"timestamp"`
+	Count     string `json:"count"`
+	Sum       string `json:"sum"`
+	Schema    int32  `json:"schema"`
+}
+
+type Exemplar struct {
+	Labels    map[string]string `json:"labels"`
+	Timestamp int64             `json:"timestamp"`
+	Value     string            `json:"value"`
+}
+
+// Series is the JSON representation of a received series. Values are
+// formatted as strings to support NaN and staleness markers.
+type Series struct {
+	Labels     map[string]string `json:"labels"`
+	Type       string            `json:"type,omitempty"`
+	Samples    []Sample          `json:"samples,omitempty"`
+	Histograms []Histogram       `json:"histograms,omitempty"`
+	Exemplars  []Exemplar        `json:"exemplars,omitempty"`
+}
+
+func NewReceiver() *Receiver {
+	return &Receiver{
+		series:   map[string]*prometheus.TimeSeries{},
+		metadata: map[string]*prometheus.MetricMetadata{},
+	}
+}
+
+// Handler returns a handler serving the write and series endpoints
+func (r *Receiver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(WritePath, r.handleWrite)
+	mux.HandleFunc(SeriesPath, r.handleSeries)
+	return mux
+}
+
+func (r *Receiver) handleWrite(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	compressed, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v2 := strings.Contains(req.Header.Get("Content-Type"), remotewrite.ProtocolV2)
+	wr := &prometheus.WriteRequest{}
+	if v2 {
+		wr, err = remotewrite.UnmarshalV2(data)
+	} else {
+		err = proto.Unmarshal(data, wr)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	samples, histograms, exemplars := r.Append(wr)
+	if v2 {
+		w.Header().Set(remotewrite.SamplesWrittenHeader, strconv.Itoa(samples))
+		w.Header().Set(remotewrite.HistogramsWrittenHeader, strconv.Itoa(histograms))
+		w.Header().Set(remotewrite.ExemplarsWrittenHeader, strconv.Itoa(exemplars))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Receiver) handleSeries(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.Series(req.URL.Query().Get("match")))
+	case http.MethodDelete:
+		r.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Append stores the contents of a write request and returns the number of
+// samples, histograms and exemplars written
+func (r *Receiver) Append(wr *prometheus.WriteRequest) (int, int, int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	samples, histograms, exemplars := 0, 0, 0
+	for _, ts := range wr.Timeseries {
+		key := seriesKey(ts.Labels)
+		stored, ok := r.series[key]
+		if !ok {
+			stored = &prometheus.TimeSeries{
+				Labels: ts.Labels,
+			}
+			r.series[key] = stored
+		}
+		stored.Samples = append(stored.Samples, ts.Samples...)
+		stored.Histograms = append(stored.Histograms, ts.Histograms...)
+		stored.Exemplars = append(stored.Exemplars, ts.Exemplars...)
+		samples += len(ts.Samples)
+		histograms += len(ts.Histograms)
+		exemplars += len(ts.Exemplars)
+	}
+
+	for _, metadata := range wr.Metadata {
+		r.metadata[metadata.MetricFamilyName] = metadata
+	}
+
+	return samples, histograms, exemplars
+}
+
+// Series returns all received series, optionally only those with the given
+// metric name
+func (r *Receiver) Series(name string) []Series {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var keys []string
+	for key := range r.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := []Series{}
+	for _, key := range keys {
+		ts := r.series[key]
+		series := Series{
+			Labels: map[string]string{},
+		}
+		for _, label := range ts.Labels {
+			series.Labels[label.Name] = label.Value
+		}
+		if name != "" && series.Labels["__name__"] != name {
+			continue
+		}
+		if metadata := r.findMetadata(series.Labels["__name__"]); metadata != nil {
+			series.Type = strings.ToLower(metadata.Type.String())
+		}
+		for _, sample := range ts.Samples {
+			series.Samples = append(series.Samples, Sample{
+				Timestamp: sample.Timestamp,
+				Value:     formatFloat(sample.Value),
+			})
+		}
+		for _, histogram := range ts.Histograms {
+			count := ""
+			switch c := histogram.Count.(type) {
+			case *prometheus.Histogram_CountInt:
+				count = strconv.FormatUint(c.CountInt, 10)
+			case *prometheus.Histogram_CountFloat:
+				count = formatFloat(c.CountFloat)
+			}
+			series.Histograms = append(series.Histograms, Histogram{
+				Timestamp: histogram.Timestamp,
+				Count:     count,
+				Sum:       formatFloat(histogram.Sum),
+				Schema:    histogram.Schema,
+			})
+		}
+		for _, exemplar := range ts.Exemplars {
+			labels := map[string]string{}
+			for _, label := range exemplar.Labels {
+				labels[label.Name] = label.Value
+			}
+			series.Exemplars = append(series.Exemplars, Exemplar{
+				Labels:    labels,
+				Timestamp: exemplar.Timestamp,
+				Value:     formatFloat(exemplar.Value),
+			})
+		}
+		result = append(result, series)
+	}
+	return result
+}
+
+// findMetadata returns the metadata of the metric family of a series, the
+// caller must hold the lock
+func (r *Receiver) findMetadata(name string) *prometheus.MetricMetadata {
+	if metadata, ok := r.metadata[name]; ok {
+		return metadata
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if metadata, ok := r.metadata[strings.TrimSuffix(name, suffix)]; ok {
+			return metadata
+		}
+	}
+	return nil
+}
+
+// Reset removes all received series
+func (r *Receiver) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.series = map[string]*prometheus.TimeSeries{}
+	r.metadata = map[string]*prometheus.MetricMetadata{}
+}
+
+// Dump writes all received series to a file as JSON
+func (r *Receiver) Dump(file string) error {
+	data, err := json.MarshalIndent(r.Series(""), "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error writing dump file %v: %v", file, err))
+	}
+	return nil
+}
+
+// seriesKey identifies a series by its labels, names and values are quoted
+// so that values containing quotes or commas can't collide
+func seriesKey(labels []*prometheus.Label) string {
+	var pairs []string
+	for _, label := range labels {
+		pairs = append(pairs, strconv.Quote(label.Name)+"="+strconv.Quote(label.Value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(value float64) string {
+	if math.Float64bits(value) == math.Float64bits(progression.StaleNaN) {
+		return "stale"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
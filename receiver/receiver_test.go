@@ -0,0 +1,143 @@
+package receiver
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"write/progression"
+	"write/remotewrite"
+)
+
+func writeRequest() *prometheus.WriteRequest {
+	return &prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			{
+				Labels: []*prometheus.Label{{Name: "__name__", Value: "requests_total"}, {Name: "job", Value: "api"}},
+				Samples: []*prometheus.Sample{
+					{Value: 1, Timestamp: 1000},
+					{Value: progression.StaleNaN, Timestamp: 2000},
+				},
+				Exemplars: []*prometheus.Exemplar{
+					{Labels: []*prometheus.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1000},
+				},
+			},
+			{
+				Labels: []*prometheus.Label{{Name: "__name__", Value: "latency_seconds"}, {Name: "job", Value: "api"}},
+				Histograms: []*prometheus.Histogram{
+					{
+						Count:          &prometheus.Histogram_CountInt{CountInt: 2},
+						Sum:            1.5,
+						Schema:         0,
+						ZeroCount:      &prometheus.Histogram_ZeroCountInt{ZeroCountInt: 0},
+						PositiveSpans:  []*prometheus.BucketSpan{{Offset: 1, Length: 1}},
+						PositiveDeltas: []int64{2},
+						Timestamp:      1000,
+					},
+				},
+			},
+		},
+		Metadata: []*prometheus.MetricMetadata{
+			{MetricFamilyName: "requests_total", Type: prometheus.MetricMetadata_COUNTER},
+		},
+	}
+}
+
+func post(t *testing.T, server *httptest.Server, contentType string, data []byte) *http.Response {
+	request, err := http.NewRequest(http.MethodPost, server.URL+WritePath, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status %v", response.Status)
+	}
+	return response
+}
+
+func checkSeries(t *testing.T, r *Receiver) {
+	series := r.Series("")
+	if len(series) != 2 {
+		t.Fatalf("expected 2 series, got %v", series)
+	}
+	// series are sorted by their labels
+	histogram, counter := series[0], series[1]
+	if counter.Labels["__name__"] != "requests_total" || counter.Type != "counter" {
+		t.Errorf("unexpected counter %v", counter)
+	}
+	if len(counter.Samples) != 2 || counter.Samples[0].Value != "1" || counter.Samples[1].Value != "stale" {
+		t.Errorf("unexpected samples %v", counter.Samples)
+	}
+	if len(counter.Exemplars) != 1 || counter.Exemplars[0].Labels["trace_id"] != "abc" {
+		t.Errorf("unexpected exemplars %v", counter.Exemplars)
+	}
+	if len(histogram.Histograms) != 1 || histogram.Histograms[0].Count != "2" || histogram.Histograms[0].Sum != "1.5" {
+		t.Errorf("unexpected histograms %v", histogram.Histograms)
+	}
+	if filtered := r.Series("latency_seconds"); len(filtered) != 1 {
+		t.Errorf("expected one series named latency_seconds, got %v", filtered)
+	}
+}
+
+func TestWriteV1(t *testing.T) {
+	r := NewReceiver()
+	server := httptest.NewServer(r.Handler())
+	defer server.Close()
+
+	data, err := proto.Marshal(writeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := post(t, server, remotewrite.ContentType(remotewrite.ProtocolV1), data)
+	if written := response.Header.Get(remotewrite.SamplesWrittenHeader); written != "" {
+		t.Errorf("expected no written counts for remote write 1.0, got %v", written)
+	}
+	checkSeries(t, r)
+}
+
+func TestWriteV2(t *testing.T) {
+	r := NewReceiver()
+	server := httptest.NewServer(r.Handler())
+	defer server.Close()
+
+	wr := writeRequest()
+	response := post(t, server, remotewrite.ContentType(remotewrite.ProtocolV2), remotewrite.MarshalV2(wr, make([]int64, len(wr.Timeseries))))
+	for header, expected := range map[string]string{
+		remotewrite.SamplesWrittenHeader:    "2",
+		remotewrite.HistogramsWrittenHeader: "1",
+		remotewrite.ExemplarsWrittenHeader:  "1",
+	} {
+		if written := response.Header.Get(header); written != expected {
+			t.Errorf("expected %v %v, got %q", header, expected, written)
+		}
+	}
+	checkSeries(t, r)
+}
+
+func TestAppendKeepsSimilarSeriesApart(t *testing.T) {
+	r := NewReceiver()
+	r.Append(&prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			{
+				Labels:  []*prometheus.Label{{Name: "a", Value: "x"}, {Name: "b", Value: "y"}},
+				Samples: []*prometheus.Sample{{Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels:  []*prometheus.Label{{Name: "a", Value: `x",b="y`}},
+				Samples: []*prometheus.Sample{{Value: math.Inf(1), Timestamp: 1000}},
+			},
+		},
+	})
+	if series := r.Series(""); len(series) != 2 {
+		t.Errorf("expected 2 series, got %v", series)
+	}
+}
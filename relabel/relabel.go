@@ -0,0 +1,197 @@
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ActionReplace sets the target label to the replacement if the regex
+	// matches the joined source labels, an empty result removes it
+	ActionReplace = "replace"
+	// ActionKeep drops series whose source labels don't match the regex,
+	// without source labels the regex is matched against the empty string
+	ActionKeep = "keep"
+	// ActionDrop drops series whose source labels match the regex
+	ActionDrop = "drop"
+	// ActionHashMod sets the target label to the modulus of a hash of the
+	// source labels
+	ActionHashMod = "hashmod"
+	// ActionLabelMap copies the labels whose names match the regex to the
+	// names given by the replacement
+	ActionLabelMap = "labelmap"
+	// ActionLabelDrop removes the labels whose names match the regex
+	ActionLabelDrop = "labeldrop"
+	// ActionLabelKeep removes the labels whose names don't match the regex
+	ActionLabelKeep = "labelkeep"
+
+	DefaultSeparator   = ";"
+	DefaultRegex       = "(.*)"
+	DefaultReplacement = "$1"
+)
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Rule is a relabeling step with the semantics of Prometheus relabel_configs
+type Rule struct {
+	action       string
+	sourceLabels []string
+	separator    string
+	targetLabel  string
+	regex        *regexp.Regexp
+	modulus      uint64
+	replacement  string
+}
+
+func NewRule(action string, sourceLabels []string, separator string, targetLabel string, regex string, modulus uint64, replacement string) (*Rule, error) {
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid relabel regex %v: %v", regex, err))
+	}
+
+	switch action {
+	case ActionReplace:
+		if targetLabel == "" {
+			return nil, errors.New("missing value: relabel target_label for action replace")
+		}
+	case ActionHashMod:
+		if targetLabel == "" {
+			return nil, errors.New("missing value: relabel target_label for action hashmod")
+		}
+		if modulus == 0 {
+			return nil, errors.New("missing value: relabel modulus for action hashmod")
+		}
+	case ActionKeep, ActionDrop, ActionLabelMap, ActionLabelDrop, ActionLabelKeep:
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown relabel action: %v", action))
+	}
+
+	return &Rule{
+		action:       action,
+		sourceLabels: sourceLabels,
+		separator:    separator,
+		targetLabel:  targetLabel,
+		regex:        re,
+		modulus:      modulus,
+		replacement:  replacement,
+	}, nil
+}
+
+// apply changes the labels in place and returns false if the series is
+// dropped
+func (r *Rule) apply(labels *labelSet) bool {
+	var values []string
+	for _, name := range r.sourceLabels {
+		values = append(values, labels.get(name))
+	}
+	value := strings.Join(values, r.separator)
+
+	switch r.action {
+	case ActionKeep:
+		return r.regex.MatchString(value)
+	case ActionDrop:
+		return !r.regex.MatchString(value)
+	case ActionReplace:
+		indexes := r.regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			return true
+		}
+		target := string(r.regex.ExpandString(nil, r.targetLabel, value, indexes))
+		if !labelName.MatchString(target) {
+			return true
+		}
+		replacement := string(r.regex.ExpandString(nil, r.replacement, value, indexes))
+		if replacement == "" {
+			labels.del(target)
+		} else {
+			labels.set(target, replacement)
+		}
+	case ActionHashMod:
+		sum := md5.Sum([]byte(value))
+		labels.set(r.targetLabel, fmt.Sprintf("%v", binary.BigEndian.Uint64(sum[8:])%r.modulus))
+	case ActionLabelMap:
+		var mapped []*prometheus.Label
+		for _, label := range labels.labels {
+			if r.regex.MatchString(label.Name) {
+				mapped = append(mapped, &prometheus.Label{
+					Name:  r.regex.ReplaceAllString(label.Name, r.replacement),
+					Value: label.Value,
+				})
+			}
+		}
+		for _, label := range mapped {
+			labels.set(label.Name, label.Value)
+		}
+	case ActionLabelDrop, ActionLabelKeep:
+		var kept []*prometheus.Label
+		for _, label := range labels.labels {
+			if r.regex.MatchString(label.Name) == (r.action == ActionLabelKeep) {
+				kept = append(kept, label)
+			}
+		}
+		labels.labels = kept
+	}
+	return true
+}
+
+// Rules are applied one after the other
+type Rules []*Rule
+
+// Apply returns the relabeled copy of labels, or false if the series is
+// dropped. The labels passed in are not changed.
+func (r Rules) Apply(labels []*prometheus.Label) ([]*prometheus.Label, bool) {
+	set := &labelSet{}
+	for _, label := range labels {
+		set.labels = append(set.labels, &prometheus.Label{
+			Name:  label.Name,
+			Value: label.Value,
+		})
+	}
+	for _, rule := range r {
+		if !rule.apply(set) {
+			return nil, false
+		}
+	}
+	return set.labels, true
+}
+
+// labelSet keeps labels in their order, new labels are appended
+type labelSet struct {
+	labels []*prometheus.Label
+}
+
+func (s *labelSet) get(name string) string {
+	for _, label := range s.labels {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+func (s *labelSet) set(name string, value string) {
+	for _, label := range s.labels {
+		if label.Name == name {
+			label.Value = value
+			return
+		}
+	}
+	s.labels = append(s.labels, &prometheus.Label{
+		Name:  name,
+		Value: value,
+	})
+}
+
+func (s *labelSet) del(name string) {
+	for i, label := range s.labels {
+		if label.Name == name {
+			s.labels = append(s.labels[:i], s.labels[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package relabel
+
+import (
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"reflect"
+	"testing"
+)
+
+func TestKeepDropWithoutSourceLabels(t *testing.T) {
+	labels := []*prometheus.Label{{Name: "__name__", Value: "up"}}
+	for _, test := range []struct {
+		action string
+		regex  string
+		keep   bool
+	}{
+		{action: ActionKeep, regex: "", keep: true},
+		{action: ActionKeep, regex: ".+", keep: false},
+		{action: ActionDrop, regex: "", keep: false},
+		{action: ActionDrop, regex: "up", keep: true},
+	} {
+		rule, err := NewRule(test.action, nil, DefaultSeparator, "", test.regex, 0, DefaultReplacement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, keep := Rules{rule}.Apply(labels)
+		if keep != test.keep {
+			t.Errorf("%v %q: expected keep %v, got %v", test.action, test.regex, test.keep, keep)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	newRule := func(action string, sourceLabels []string, targetLabel string, regex string, replacement string) *Rule {
+		rule, err := NewRule(action, sourceLabels, DefaultSeparator, targetLabel, regex, 4, replacement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rule
+	}
+	rules := Rules{
+		newRule(ActionDrop, []string{"__name__", "le"}, "", "latency_bucket;0.1", DefaultReplacement),
+		newRule(ActionReplace, []string{"job"}, "service", "(.*)-.*", "$1"),
+		newRule(ActionLabelDrop, nil, "", "job", DefaultReplacement),
+	}
+
+	labels := []*prometheus.Label{{Name: "__name__", Value: "latency_bucket"}, {Name: "job", Value: "api-1"}, {Name: "le", Value: "0.1"}}
+	if _, keep := rules.Apply(labels); keep {
+		t.Error("expected the 0.1 bucket to be dropped")
+	}
+
+	labels[2].Value = "+Inf"
+	got, keep := rules.Apply(labels)
+	expected := []*prometheus.Label{{Name: "__name__", Value: "latency_bucket"}, {Name: "le", Value: "+Inf"}, {Name: "service", Value: "api"}}
+	if !keep || !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if labels[1].Value != "api-1" || len(labels) != 3 {
+		t.Errorf("the labels passed in were changed: %v", labels)
+	}
+}
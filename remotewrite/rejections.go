@@ -0,0 +1,99 @@
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rejection is the reason a receiver rejected samples with 400 Bad Request
+type Rejection string
+
+const (
+	RejectionOutOfOrder    Rejection = "out_of_order"
+	RejectionDuplicate     Rejection = "duplicate"
+	RejectionTooOld        Rejection = "too_old"
+	RejectionInvalidLabels Rejection = "invalid_labels"
+	RejectionLimits        Rejection = "limits"
+	RejectionUnknown       Rejection = "unknown"
+)
+
+// Action is what to do about a rejection
+type Action string
+
+const (
+	ActionIgnore Action = "ignore"
+	ActionWarn   Action = "warn"
+	ActionFail   Action = "fail"
+)
+
+// DefaultPolicy ignores duplicates, which are expected when a run is
+// repeated, and fails on invalid labels, which are bugs in the config
+const DefaultPolicy = "out_of_order=warn,duplicate=ignore,too_old=warn,invalid_labels=fail,limits=warn,unknown=warn"
+
+// Policy maps rejections to actions
+type Policy map[Rejection]Action
+
+// rejectionPatterns match the error messages of Prometheus, Mimir, Cortex,
+// Thanos and VictoriaMetrics. They are tried in order, the label patterns come
+// first as "duplicate label names" is not a duplicate sample.
+var rejectionPatterns = []struct {
+	rejection Rejection
+	pattern   *regexp.Regexp
+}{
+	{RejectionInvalidLabels, regexp.MustCompile(`(?i)invalid (metric|label)|label name|label value|duplicate label|labels? (is|are) not sorted|missing metric name|metric name.*(missing|empty)|utf-?8`)},
+	{RejectionLimits, regexp.MustCompile(`(?i)limit|too many|too long|exceed`)},
+	{RejectionOutOfOrder, regexp.MustCompile(`(?i)out[ -]of[ -]order`)},
+	{RejectionDuplicate, regexp.MustCompile(`(?i)duplicate|new value for timestamp|same timestamp`)},
+	{RejectionTooOld, regexp.MustCompile(`(?i)out of bounds|too old|too far in the past|older than`)},
+}
+
+// seriesPattern matches the label sets receivers quote in their errors
+var seriesPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Classify returns the reason of a rejection from the body of a 400
+// response
+func Classify(body string) Rejection {
+	for _, p := range rejectionPatterns {
+		if p.pattern.MatchString(body) {
+			return p.rejection
+		}
+	}
+	return RejectionUnknown
+}
+
+// RejectedSeries returns the series quoted in the body of a 400 response
+func RejectedSeries(body string) []string {
+	return seriesPattern.FindAllString(body, -1)
+}
+
+// ParsePolicy parses a comma separated list of rejection=action pairs.
+// Rejections that aren't listed keep their action from DefaultPolicy.
+func ParsePolicy(value string) (Policy, error) {
+	policy := Policy{}
+	for _, list := range []string{DefaultPolicy, value} {
+		for _, pair := range strings.Split(list, ",") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			rejection, action, found := strings.Cut(pair, "=")
+			if !found {
+				return nil, errors.New(fmt.Sprintf("invalid rejection policy: %v", pair))
+			}
+			switch Rejection(rejection) {
+			case RejectionOutOfOrder, RejectionDuplicate, RejectionTooOld, RejectionInvalidLabels, RejectionLimits, RejectionUnknown:
+			default:
+				return nil, errors.New(fmt.Sprintf("unknown rejection: %v", rejection))
+			}
+			switch Action(action) {
+			case ActionIgnore, ActionWarn, ActionFail:
+			default:
+				return nil, errors.New(fmt.Sprintf("unknown rejection action: %v", action))
+			}
+			policy[Rejection(rejection)] = Action(action)
+		}
+	}
+	return policy, nil
+}
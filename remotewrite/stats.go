@@ -0,0 +1,44 @@
+package remotewrite
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	SamplesWrittenHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	HistogramsWrittenHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	ExemplarsWrittenHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
+// WriteStats are the number of samples, histograms and exemplars a Remote
+// Write 2.0 receiver reports as written
+type WriteStats struct {
+	Samples    int64
+	Histograms int64
+	Exemplars  int64
+	// Confirmed is false if the receiver did not send any of the headers,
+	// which usually means it does not support Remote Write 2.0
+	Confirmed bool
+}
+
+func ParseWriteStats(header http.Header) WriteStats {
+	stats := WriteStats{}
+	for name, target := range map[string]*int64{
+		SamplesWrittenHeader:    &stats.Samples,
+		HistogramsWrittenHeader: &stats.Histograms,
+		ExemplarsWrittenHeader:  &stats.Exemplars,
+	} {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		*target = parsed
+		stats.Confirmed = true
+	}
+	return stats
+}
@@ -0,0 +1,305 @@
+package remotewrite
+
+import (
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+)
+
+const (
+	ProtocolV1 = "prometheus.WriteRequest"
+	ProtocolV2 = "io.prometheus.write.v2.Request"
+)
+
+// field numbers of the io.prometheus.write.v2 messages
+const (
+	requestSymbols    protowire.Number = 4
+	requestTimeseries protowire.Number = 5
+
+	timeseriesLabelsRefs       protowire.Number = 1
+	timeseriesSamples          protowire.Number = 2
+	timeseriesHistograms       protowire.Number = 3
+	timeseriesExemplars        protowire.Number = 4
+	timeseriesMetadata         protowire.Number = 5
+	timeseriesCreatedTimestamp protowire.Number = 6
+
+	sampleValue     protowire.Number = 1
+	sampleTimestamp protowire.Number = 2
+
+	exemplarLabelsRefs protowire.Number = 1
+	exemplarValue      protowire.Number = 2
+	exemplarTimestamp  protowire.Number = 3
+
+	metadataType    protowire.Number = 1
+	metadataHelpRef protowire.Number = 3
+	metadataUnitRef protowire.Number = 4
+
+	histogramCountInt       protowire.Number = 1
+	histogramCountFloat     protowire.Number = 2
+	histogramSum            protowire.Number = 3
+	histogramSchema         protowire.Number = 4
+	histogramZeroThreshold  protowire.Number = 5
+	histogramZeroCountInt   protowire.Number = 6
+	histogramZeroCountFloat protowire.Number = 7
+	histogramNegativeSpans  protowire.Number = 8
+	histogramNegativeDeltas protowire.Number = 9
+	histogramNegativeCounts protowire.Number = 10
+	histogramPositiveSpans  protowire.Number = 11
+	histogramPositiveDeltas protowire.Number = 12
+	histogramPositiveCounts protowire.Number = 13
+	histogramResetHint      protowire.Number = 14
+	histogramTimestamp      protowire.Number = 15
+
+	bucketSpanOffset protowire.Number = 1
+	bucketSpanLength protowire.Number = 2
+)
+
+// symbols is the symbol table of a Remote Write 2.0 request, all strings are
+// referenced by their index in the table
+type symbols struct {
+	refs    map[string]uint32
+	symbols []string
+}
+
+func newSymbols() *symbols {
+	// the empty string is always the first symbol
+	return &symbols{
+		refs:    map[string]uint32{"": 0},
+		symbols: []string{""},
+	}
+}
+
+func (s *symbols) ref(symbol string) uint32 {
+	if ref, ok := s.refs[symbol]; ok {
+		return ref
+	}
+	ref := uint32(len(s.symbols))
+	s.refs[symbol] = ref
+	s.symbols = append(s.symbols, symbol)
+	return ref
+}
+
+func (s *symbols) labelRefs(labels []*prometheus.Label) []uint32 {
+	var refs []uint32
+	for _, label := range labels {
+		refs = append(refs, s.ref(label.Name), s.ref(label.Value))
+	}
+	return refs
+}
+
+// MarshalV2 converts a Remote Write 1.0 request into a Remote Write 2.0
+// request. Metadata is attached to every series of its metric family.
+// created holds the created timestamp of each series, it is only sent for
+// counters, histograms and summaries.
+func MarshalV2(wr *prometheus.WriteRequest, created []int64) []byte {
+	table := newSymbols()
+	var timeseries [][]byte
+	for i, ts := range wr.Timeseries {
+		timeseries = append(timeseries, marshalTimeseries(table, ts, FindMetadata(wr.Metadata, ts.Labels), created[i]))
+	}
+
+	var b []byte
+	for _, symbol := range table.symbols {
+		b = protowire.AppendTag(b, requestSymbols, protowire.BytesType)
+		b = protowire.AppendString(b, symbol)
+	}
+	for _, ts := range timeseries {
+		b = protowire.AppendTag(b, requestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
+
+// FindMetadata returns the metadata of the metric family a series belongs to
+func FindMetadata(metadata []*prometheus.MetricMetadata, labels []*prometheus.Label) *prometheus.MetricMetadata {
+	name := ""
+	for _, label := range labels {
+		if label.Name == "__name__" {
+			name = label.Value
+		}
+	}
+
+	for _, m := range metadata {
+		if m.MetricFamilyName == name {
+			return m
+		}
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if m.MetricFamilyName+suffix == name {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+func marshalTimeseries(table *symbols, ts *prometheus.TimeSeries, metadata *prometheus.MetricMetadata, created int64) []byte {
+	var b []byte
+	b = appendPackedRefs(b, timeseriesLabelsRefs, table.labelRefs(ts.Labels))
+
+	for _, sample := range ts.Samples {
+		var s []byte
+		s = appendDouble(s, sampleValue, sample.Value)
+		s = appendInt64(s, sampleTimestamp, sample.Timestamp)
+		b = protowire.AppendTag(b, timeseriesSamples, protowire.BytesType)
+		b = protowire.AppendBytes(b, s)
+	}
+
+	for _, histogram := range ts.Histograms {
+		b = protowire.AppendTag(b, timeseriesHistograms, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalHistogram(histogram))
+	}
+
+	for _, exemplar := range ts.Exemplars {
+		var e []byte
+		e = appendPackedRefs(e, exemplarLabelsRefs, table.labelRefs(exemplar.Labels))
+		e = appendDouble(e, exemplarValue, exemplar.Value)
+		e = appendInt64(e, exemplarTimestamp, exemplar.Timestamp)
+		b = protowire.AppendTag(b, timeseriesExemplars, protowire.BytesType)
+		b = protowire.AppendBytes(b, e)
+	}
+
+	if metadata != nil {
+		// the metric types of both protocol versions share the same values
+		var m []byte
+		m = appendUint64(m, metadataType, uint64(metadata.Type))
+		m = appendUint64(m, metadataHelpRef, uint64(table.ref(metadata.Help)))
+		m = appendUint64(m, metadataUnitRef, uint64(table.ref(metadata.Unit)))
+		b = protowire.AppendTag(b, timeseriesMetadata, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+
+		switch metadata.Type {
+		case prometheus.MetricMetadata_COUNTER, prometheus.MetricMetadata_HISTOGRAM, prometheus.MetricMetadata_SUMMARY:
+			b = appendInt64(b, timeseriesCreatedTimestamp, created)
+		}
+	}
+
+	return b
+}
+
+func marshalHistogram(h *prometheus.Histogram) []byte {
+	var b []byte
+	switch count := h.Count.(type) {
+	case *prometheus.Histogram_CountInt:
+		b = protowire.AppendTag(b, histogramCountInt, protowire.VarintType)
+		b = protowire.AppendVarint(b, count.CountInt)
+	case *prometheus.Histogram_CountFloat:
+		b = protowire.AppendTag(b, histogramCountFloat, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(count.CountFloat))
+	}
+	b = appendDouble(b, histogramSum, h.Sum)
+	b = appendUint64(b, histogramSchema, protowire.EncodeZigZag(int64(h.Schema)))
+	b = appendDouble(b, histogramZeroThreshold, h.ZeroThreshold)
+	switch zeroCount := h.ZeroCount.(type) {
+	case *prometheus.Histogram_ZeroCountInt:
+		b = protowire.AppendTag(b, histogramZeroCountInt, protowire.VarintType)
+		b = protowire.AppendVarint(b, zeroCount.ZeroCountInt)
+	case *prometheus.Histogram_ZeroCountFloat:
+		b = protowire.AppendTag(b, histogramZeroCountFloat, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(zeroCount.ZeroCountFloat))
+	}
+	b = appendSpans(b, histogramNegativeSpans, h.NegativeSpans)
+	b = appendPackedDeltas(b, histogramNegativeDeltas, h.NegativeDeltas)
+	b = appendPackedDoubles(b, histogramNegativeCounts, h.NegativeCounts)
+	b = appendSpans(b, histogramPositiveSpans, h.PositiveSpans)
+	b = appendPackedDeltas(b, histogramPositiveDeltas, h.PositiveDeltas)
+	b = appendPackedDoubles(b, histogramPositiveCounts, h.PositiveCounts)
+	b = appendUint64(b, histogramResetHint, uint64(h.ResetHint))
+	b = appendInt64(b, histogramTimestamp, h.Timestamp)
+	return b
+}
+
+func appendSpans(b []byte, num protowire.Number, spans []*prometheus.BucketSpan) []byte {
+	for _, span := range spans {
+		var s []byte
+		s = appendUint64(s, bucketSpanOffset, protowire.EncodeZigZag(int64(span.Offset)))
+		s = appendUint64(s, bucketSpanLength, uint64(span.Length))
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, s)
+	}
+	return b
+}
+
+func appendPackedRefs(b []byte, num protowire.Number, refs []uint32) []byte {
+	if len(refs) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, ref := range refs {
+		packed = protowire.AppendVarint(packed, uint64(ref))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+func appendPackedDeltas(b []byte, num protowire.Number, deltas []int64) []byte {
+	if len(deltas) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, delta := range deltas {
+		packed = protowire.AppendVarint(packed, protowire.EncodeZigZag(delta))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+func appendPackedDoubles(b []byte, num protowire.Number, values []float64) []byte {
+	if len(values) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, value := range values {
+		packed = protowire.AppendFixed64(packed, math.Float64bits(value))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+// appendDouble, appendInt64 and appendUint64 skip default values like the
+// generated proto3 code does
+func appendDouble(b []byte, num protowire.Number, value float64) []byte {
+	if value == 0 && !math.Signbit(value) {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(value))
+}
+
+func appendInt64(b []byte, num protowire.Number, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func appendUint64(b []byte, num protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, value)
+}
+
+// ContentType returns the content type header value of a protocol
+func ContentType(protocol string) string {
+	if protocol == ProtocolV2 {
+		return "application/x-protobuf;proto=" + ProtocolV2
+	}
+	return "application/x-protobuf"
+}
+
+// Version returns the X-Prometheus-Remote-Write-Version header value of a
+// protocol
+func Version(protocol string) string {
+	if protocol == ProtocolV2 {
+		return "2.0.0"
+	}
+	return "0.1.0"
+}
+
+// IsValidProtocol returns true if the protocol is one of the supported
+// protobuf messages
+func IsValidProtocol(protocol string) bool {
+	return protocol == ProtocolV1 || protocol == ProtocolV2
+}
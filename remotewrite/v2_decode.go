@@ -0,0 +1,338 @@
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"strings"
+)
+
+// field is a single decoded protobuf field
+type field struct {
+	num   protowire.Number
+	typ   protowire.Type
+	value uint64
+	bytes []byte
+}
+
+// fields splits a protobuf message into its fields
+func fields(b []byte) ([]field, error) {
+	var result []field
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var value uint32
+			value, n = protowire.ConsumeFixed32(b)
+			f.value = uint64(value)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// packed decodes a packed repeated varint field, unpacked values are
+// accepted too
+func packed(f field) ([]uint64, error) {
+	if f.typ == protowire.VarintType {
+		return []uint64{f.value}, nil
+	}
+	var values []uint64
+	b := f.bytes
+	for len(b) > 0 {
+		value, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		values = append(values, value)
+		b = b[n:]
+	}
+	return values, nil
+}
+
+func packedDoubles(f field) ([]float64, error) {
+	if f.typ == protowire.Fixed64Type {
+		return []float64{math.Float64frombits(f.value)}, nil
+	}
+	var values []float64
+	b := f.bytes
+	for len(b) > 0 {
+		value, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		values = append(values, math.Float64frombits(value))
+		b = b[n:]
+	}
+	return values, nil
+}
+
+func resolveLabels(table []string, refs []uint64) ([]*prometheus.Label, error) {
+	if len(refs)%2 != 0 {
+		return nil, errors.New("odd number of label references")
+	}
+	var labels []*prometheus.Label
+	for i := 0; i < len(refs); i += 2 {
+		if refs[i] >= uint64(len(table)) || refs[i+1] >= uint64(len(table)) {
+			return nil, errors.New(fmt.Sprintf("label reference out of range: %v", refs[i:i+2]))
+		}
+		labels = append(labels, &prometheus.Label{
+			Name:  table[refs[i]],
+			Value: table[refs[i+1]],
+		})
+	}
+	return labels, nil
+}
+
+// UnmarshalV2 decodes a Remote Write 2.0 request into the equivalent Remote
+// Write 1.0 request. Metadata is returned per metric family, created
+// timestamps are dropped.
+func UnmarshalV2(b []byte) (*prometheus.WriteRequest, error) {
+	requestFields, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// the symbol table has to be complete before series can be resolved
+	var table []string
+	for _, f := range requestFields {
+		if f.num == requestSymbols {
+			table = append(table, string(f.bytes))
+		}
+	}
+
+	wr := &prometheus.WriteRequest{}
+	families := map[string]bool{}
+	for _, f := range requestFields {
+		if f.num != requestTimeseries {
+			continue
+		}
+		ts, metadata, err := unmarshalTimeseries(table, f.bytes)
+		if err != nil {
+			return nil, err
+		}
+		wr.Timeseries = append(wr.Timeseries, ts)
+		if metadata != nil && !families[metadata.MetricFamilyName] {
+			families[metadata.MetricFamilyName] = true
+			wr.Metadata = append(wr.Metadata, metadata)
+		}
+	}
+	return wr, nil
+}
+
+func unmarshalTimeseries(table []string, b []byte) (*prometheus.TimeSeries, *prometheus.MetricMetadata, error) {
+	tsFields, err := fields(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ts := &prometheus.TimeSeries{}
+	var metadata *prometheus.MetricMetadata
+	var refs []uint64
+	for _, f := range tsFields {
+		switch f.num {
+		case timeseriesLabelsRefs:
+			values, err := packed(f)
+			if err != nil {
+				return nil, nil, err
+			}
+			refs = append(refs, values...)
+		case timeseriesSamples:
+			sample := &prometheus.Sample{}
+			sampleFields, err := fields(f.bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, sf := range sampleFields {
+				switch sf.num {
+				case sampleValue:
+					sample.Value = math.Float64frombits(sf.value)
+				case sampleTimestamp:
+					sample.Timestamp = int64(sf.value)
+				}
+			}
+			ts.Samples = append(ts.Samples, sample)
+		case timeseriesHistograms:
+			histogram, err := unmarshalHistogram(f.bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			ts.Histograms = append(ts.Histograms, histogram)
+		case timeseriesExemplars:
+			exemplar := &prometheus.Exemplar{}
+			exemplarFields, err := fields(f.bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			var exemplarRefs []uint64
+			for _, ef := range exemplarFields {
+				switch ef.num {
+				case exemplarLabelsRefs:
+					values, err := packed(ef)
+					if err != nil {
+						return nil, nil, err
+					}
+					exemplarRefs = append(exemplarRefs, values...)
+				case exemplarValue:
+					exemplar.Value = math.Float64frombits(ef.value)
+				case exemplarTimestamp:
+					exemplar.Timestamp = int64(ef.value)
+				}
+			}
+			exemplar.Labels, err = resolveLabels(table, exemplarRefs)
+			if err != nil {
+				return nil, nil, err
+			}
+			ts.Exemplars = append(ts.Exemplars, exemplar)
+		case timeseriesMetadata:
+			metadata = &prometheus.MetricMetadata{}
+			metadataFields, err := fields(f.bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, mf := range metadataFields {
+				switch mf.num {
+				case metadataType:
+					metadata.Type = prometheus.MetricMetadata_MetricType(mf.value)
+				case metadataHelpRef:
+					if mf.value < uint64(len(table)) {
+						metadata.Help = table[mf.value]
+					}
+				case metadataUnitRef:
+					if mf.value < uint64(len(table)) {
+						metadata.Unit = table[mf.value]
+					}
+				}
+			}
+		}
+	}
+
+	ts.Labels, err = resolveLabels(table, refs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if metadata != nil {
+		for _, label := range ts.Labels {
+			if label.Name == "__name__" {
+				metadata.MetricFamilyName = familyName(label.Value, metadata.Type)
+			}
+		}
+	}
+	return ts, metadata, nil
+}
+
+func unmarshalSpans(b []byte) (*prometheus.BucketSpan, error) {
+	spanFields, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+	span := &prometheus.BucketSpan{}
+	for _, f := range spanFields {
+		switch f.num {
+		case bucketSpanOffset:
+			span.Offset = int32(protowire.DecodeZigZag(f.value))
+		case bucketSpanLength:
+			span.Length = uint32(f.value)
+		}
+	}
+	return span, nil
+}
+
+func unmarshalHistogram(b []byte) (*prometheus.Histogram, error) {
+	histogramFields, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &prometheus.Histogram{}
+	for _, f := range histogramFields {
+		switch f.num {
+		case histogramCountInt:
+			h.Count = &prometheus.Histogram_CountInt{CountInt: f.value}
+		case histogramCountFloat:
+			h.Count = &prometheus.Histogram_CountFloat{CountFloat: math.Float64frombits(f.value)}
+		case histogramSum:
+			h.Sum = math.Float64frombits(f.value)
+		case histogramSchema:
+			h.Schema = int32(protowire.DecodeZigZag(f.value))
+		case histogramZeroThreshold:
+			h.ZeroThreshold = math.Float64frombits(f.value)
+		case histogramZeroCountInt:
+			h.ZeroCount = &prometheus.Histogram_ZeroCountInt{ZeroCountInt: f.value}
+		case histogramZeroCountFloat:
+			h.ZeroCount = &prometheus.Histogram_ZeroCountFloat{ZeroCountFloat: math.Float64frombits(f.value)}
+		case histogramNegativeSpans, histogramPositiveSpans:
+			span, err := unmarshalSpans(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			if f.num == histogramNegativeSpans {
+				h.NegativeSpans = append(h.NegativeSpans, span)
+			} else {
+				h.PositiveSpans = append(h.PositiveSpans, span)
+			}
+		case histogramNegativeDeltas, histogramPositiveDeltas:
+			values, err := packed(f)
+			if err != nil {
+				return nil, err
+			}
+			for _, value := range values {
+				if f.num == histogramNegativeDeltas {
+					h.NegativeDeltas = append(h.NegativeDeltas, protowire.DecodeZigZag(value))
+				} else {
+					h.PositiveDeltas = append(h.PositiveDeltas, protowire.DecodeZigZag(value))
+				}
+			}
+		case histogramNegativeCounts, histogramPositiveCounts:
+			values, err := packedDoubles(f)
+			if err != nil {
+				return nil, err
+			}
+			if f.num == histogramNegativeCounts {
+				h.NegativeCounts = append(h.NegativeCounts, values...)
+			} else {
+				h.PositiveCounts = append(h.PositiveCounts, values...)
+			}
+		case histogramResetHint:
+			h.ResetHint = prometheus.Histogram_ResetHint(f.value)
+		case histogramTimestamp:
+			h.Timestamp = int64(f.value)
+		}
+	}
+	return h, nil
+}
+
+// familyName removes the suffixes of the derived series of histograms and
+// summaries from a metric name
+func familyName(name string, metricType prometheus.MetricMetadata_MetricType) string {
+	switch metricType {
+	case prometheus.MetricMetadata_HISTOGRAM, prometheus.MetricMetadata_GAUGEHISTOGRAM, prometheus.MetricMetadata_SUMMARY:
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if strings.HasSuffix(name, suffix) {
+				return strings.TrimSuffix(name, suffix)
+			}
+		}
+	}
+	return name
+}
@@ -0,0 +1,83 @@
+package remotewrite
+
+import (
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestV2RoundTrip(t *testing.T) {
+	wr := &prometheus.WriteRequest{
+		Timeseries: []*prometheus.TimeSeries{
+			{
+				Labels: []*prometheus.Label{{Name: "__name__", Value: "requests_total"}, {Name: "job", Value: "api"}},
+				Samples: []*prometheus.Sample{
+					{Value: 1, Timestamp: 1000},
+					{Value: math.Float64frombits(0x7ff0000000000002), Timestamp: 2000},
+				},
+				Exemplars: []*prometheus.Exemplar{
+					{Labels: []*prometheus.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1000},
+				},
+			},
+			{
+				Labels: []*prometheus.Label{{Name: "__name__", Value: "latency_seconds"}, {Name: "job", Value: "api"}},
+				Histograms: []*prometheus.Histogram{
+					{
+						Count:          &prometheus.Histogram_CountInt{CountInt: 6},
+						Sum:            4.5,
+						Schema:         -1,
+						ZeroThreshold:  0.001,
+						ZeroCount:      &prometheus.Histogram_ZeroCountInt{ZeroCountInt: 1},
+						NegativeSpans:  []*prometheus.BucketSpan{{Offset: -2, Length: 1}},
+						NegativeDeltas: []int64{1},
+						PositiveSpans:  []*prometheus.BucketSpan{{Offset: 0, Length: 2}, {Offset: 3, Length: 1}},
+						PositiveDeltas: []int64{2, -1, 0},
+						Timestamp:      1000,
+					},
+				},
+			},
+			{
+				Labels:  []*prometheus.Label{{Name: "__name__", Value: "temperature"}},
+				Samples: []*prometheus.Sample{{Value: -3.5, Timestamp: 1000}},
+			},
+		},
+		Metadata: []*prometheus.MetricMetadata{
+			{MetricFamilyName: "requests_total", Type: prometheus.MetricMetadata_COUNTER},
+			{MetricFamilyName: "latency_seconds", Type: prometheus.MetricMetadata_HISTOGRAM},
+		},
+	}
+
+	decoded, err := UnmarshalV2(MarshalV2(wr, []int64{500, 600, 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.Timeseries) != len(wr.Timeseries) {
+		t.Fatalf("expected %v series, got %v", len(wr.Timeseries), len(decoded.Timeseries))
+	}
+	for i, ts := range wr.Timeseries {
+		got := decoded.Timeseries[i]
+		if !reflect.DeepEqual(ts.Labels, got.Labels) {
+			t.Errorf("series %v: expected labels %v, got %v", i, ts.Labels, got.Labels)
+		}
+		if len(ts.Samples) != len(got.Samples) {
+			t.Fatalf("series %v: expected samples %v, got %v", i, ts.Samples, got.Samples)
+		}
+		for j, sample := range ts.Samples {
+			// compare bits, the staleness marker is a NaN
+			if math.Float64bits(sample.Value) != math.Float64bits(got.Samples[j].Value) || sample.Timestamp != got.Samples[j].Timestamp {
+				t.Errorf("series %v: expected sample %v, got %v", i, sample, got.Samples[j])
+			}
+		}
+		if !reflect.DeepEqual(ts.Exemplars, got.Exemplars) {
+			t.Errorf("series %v: expected exemplars %v, got %v", i, ts.Exemplars, got.Exemplars)
+		}
+		if !reflect.DeepEqual(ts.Histograms, got.Histograms) {
+			t.Errorf("series %v: expected histograms %v, got %v", i, ts.Histograms, got.Histograms)
+		}
+	}
+	if !reflect.DeepEqual(wr.Metadata, decoded.Metadata) {
+		t.Errorf("expected metadata %v, got %v", wr.Metadata, decoded.Metadata)
+	}
+}
@@ -0,0 +1,262 @@
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchType is the comparison of a label matcher
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher compares the value of one label, a missing label has the value ""
+// like in PromQL
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewMatcher(name string, t MatchType, value string) (*Matcher, error) {
+	m := &Matcher{
+		Name:  name,
+		Type:  t,
+		Value: value,
+	}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid regular expression %v: %v", value, err))
+		}
+		m.re = re
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown match type: %v", t))
+	}
+	return m, nil
+}
+
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+// Selector is a PromQL series selector, it matches series whose labels
+// satisfy all of its matchers
+type Selector []*Matcher
+
+// Parse parses a series selector such as up{job="api",instance=~".*:9090"},
+// the metric name and the label matchers are both optional
+func Parse(value string) (Selector, error) {
+	p := &parser{input: value}
+	selector, err := p.selector()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid series selector %v: %v", value, err))
+	}
+	return selector, nil
+}
+
+func (s Selector) Matches(labels []*prometheus.Label) bool {
+	for _, m := range s {
+		value := ""
+		for _, label := range labels {
+			if label.Name == m.Name {
+				value = label.Value
+				break
+			}
+		}
+		if !m.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Selectors match series that match any of them, no selectors match all
+// series
+type Selectors []Selector
+
+func ParseAll(values []string) (Selectors, error) {
+	var selectors Selectors
+	for _, value := range values {
+		selector, err := Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+func (s Selectors) Matches(labels []*prometheus.Label) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, selector := range s {
+		if selector.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+type parser struct {
+	input string
+	index int
+}
+
+func (p *parser) skipSpace() {
+	for p.index < len(p.input) && strings.ContainsRune(" \t\n", rune(p.input[p.index])) {
+		p.index++
+	}
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func (p *parser) name() string {
+	start := p.index
+	for p.index < len(p.input) && isNameChar(p.input[p.index], p.index == start) {
+		p.index++
+	}
+	return p.input[start:p.index]
+}
+
+func (p *parser) selector() (Selector, error) {
+	var selector Selector
+	p.skipSpace()
+	if name := p.name(); name != "" {
+		m, _ := NewMatcher("__name__", MatchEqual, name)
+		selector = append(selector, m)
+	}
+	p.skipSpace()
+	if p.index < len(p.input) && p.input[p.index] == '{' {
+		p.index++
+		matchers, err := p.matchers()
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, matchers...)
+		p.skipSpace()
+	}
+	if p.index < len(p.input) {
+		return nil, errors.New(fmt.Sprintf("unexpected character %q at position %v", p.input[p.index], p.index))
+	}
+	if len(selector) == 0 {
+		return nil, errors.New("missing metric name or label matchers")
+	}
+	for _, m := range selector {
+		if !m.Matches("") {
+			return selector, nil
+		}
+	}
+	return nil, errors.New("at least one matcher must not match the empty string")
+}
+
+// quoted reads a label value in double quotes, single quotes or backticks
+// like PromQL strings, escape sequences are those of Go
+func (p *parser) quoted() (string, error) {
+	start := p.index
+	if p.index < len(p.input) && p.input[p.index] == '\'' {
+		// a single quoted string is unquoted as a double quoted one
+		var b strings.Builder
+		b.WriteByte('"')
+		for p.index++; p.index < len(p.input) && p.input[p.index] != '\''; p.index++ {
+			switch c := p.input[p.index]; {
+			case c == '\\' && p.index+1 < len(p.input) && p.input[p.index+1] == '\'':
+				b.WriteByte('\'')
+				p.index++
+			case c == '\\' && p.index+1 < len(p.input):
+				b.WriteString(p.input[p.index : p.index+2])
+				p.index++
+			case c == '"':
+				b.WriteString(`\"`)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+		if p.index < len(p.input) {
+			p.index++
+			value, err := strconv.Unquote(b.String())
+			if err == nil {
+				return value, nil
+			}
+		}
+	} else if quoted, err := strconv.QuotedPrefix(p.input[p.index:]); err == nil {
+		p.index += len(quoted)
+		value, _ := strconv.Unquote(quoted)
+		return value, nil
+	}
+	return "", errors.New(fmt.Sprintf("expected quoted label value at position %v", start))
+}
+
+func (p *parser) matchers() (Selector, error) {
+	var matchers Selector
+	for {
+		p.skipSpace()
+		if p.index >= len(p.input) {
+			return nil, errors.New("missing }")
+		}
+		if p.input[p.index] == '}' {
+			p.index++
+			return matchers, nil
+		}
+
+		name := p.name()
+		if name == "" {
+			return nil, errors.New(fmt.Sprintf("expected label name at position %v", p.index))
+		}
+		p.skipSpace()
+		var t MatchType
+		for _, candidate := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+			if strings.HasPrefix(p.input[p.index:], string(candidate)) {
+				t = candidate
+				break
+			}
+		}
+		if t == "" {
+			return nil, errors.New(fmt.Sprintf("expected =, !=, =~ or !~ at position %v", p.index))
+		}
+		p.index += len(t)
+		p.skipSpace()
+		value, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		m, err := NewMatcher(name, t, value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+
+		p.skipSpace()
+		if p.index < len(p.input) && p.input[p.index] == ',' {
+			p.index++
+		} else if p.index < len(p.input) && p.input[p.index] != '}' {
+			return nil, errors.New(fmt.Sprintf("expected , or } at position %v", p.index))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package selector
+
+import (
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		expected []Matcher
+	}{
+		{input: `up`, expected: []Matcher{{Name: "__name__", Type: MatchEqual, Value: "up"}}},
+		{input: `up{job="api"}`, expected: []Matcher{{Name: "__name__", Type: MatchEqual, Value: "up"}, {Name: "job", Type: MatchEqual, Value: "api"}}},
+		{input: ` up { job = 'api' , } `, expected: []Matcher{{Name: "__name__", Type: MatchEqual, Value: "up"}, {Name: "job", Type: MatchEqual, Value: "api"}}},
+		{input: "{job=`a\\b`}", expected: []Matcher{{Name: "job", Type: MatchEqual, Value: `a\b`}}},
+		{input: `{job='it\'s "quoted"'}`, expected: []Matcher{{Name: "job", Type: MatchEqual, Value: `it's "quoted"`}}},
+		{input: `{job="tab\there \"x\" é"}`, expected: []Matcher{{Name: "job", Type: MatchEqual, Value: "tab\there \"x\" é"}}},
+		{input: `{job='\x41\n'}`, expected: []Matcher{{Name: "job", Type: MatchEqual, Value: "A\n"}}},
+		{input: `{job=''}`, expected: nil},
+		{input: `{job!="a",env=~"prod|dev",zone!~"eu-.*"}`, expected: []Matcher{{Name: "job", Type: MatchNotEqual, Value: "a"}, {Name: "env", Type: MatchRegexp, Value: "prod|dev"}, {Name: "zone", Type: MatchNotRegexp, Value: "eu-.*"}}},
+	} {
+		selector, err := Parse(test.input)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.input, err)
+			continue
+		}
+		if len(selector) != len(test.expected) {
+			t.Errorf("%v: expected %v matchers, got %v", test.input, len(test.expected), len(selector))
+			continue
+		}
+		for i, m := range selector {
+			if m.Name != test.expected[i].Name || m.Type != test.expected[i].Type || m.Value != test.expected[i].Value {
+				t.Errorf("%v: expected %v, got %v", test.input, test.expected[i], *m)
+			}
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{
+		``,
+		`{}`,
+		` { } `,
+		`{job=""}`,
+		`{job=~".*"}`,
+		`up{`,
+		`up{job}`,
+		`up{job="api"`,
+		`up{job=api}`,
+		`up{job='api}`,
+		`up{job="api'}`,
+		`up{job='\q'}`,
+		`up{job=="api"}`,
+		`up{job="api" env="dev"}`,
+		`up{job=~"("}`,
+		`up{job="api"} x`,
+		`1up`,
+	} {
+		_, err := Parse(input)
+		if err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	labels := []*prometheus.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}}
+	for _, test := range []struct {
+		selectors []string
+		expected  bool
+	}{
+		{selectors: nil, expected: true},
+		{selectors: []string{`up`}, expected: true},
+		{selectors: []string{`up{job=~"a.*"}`}, expected: true},
+		{selectors: []string{`up{job=~"a"}`}, expected: false},
+		{selectors: []string{`up{job!~"api|web"}`}, expected: false},
+		{selectors: []string{`up{env=""}`}, expected: true},
+		{selectors: []string{`up{env!=""}`}, expected: false},
+		{selectors: []string{`down`, `{job='api'}`}, expected: true},
+		{selectors: []string{`down`, `{__name__="up",job!='api'}`}, expected: false},
+	} {
+		selectors, err := ParseAll(test.selectors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := selectors.Matches(labels); got != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.selectors, test.expected, got)
+		}
+	}
+}
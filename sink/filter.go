@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"context"
+	"write/selector"
+)
+
+// Filter only writes the series that match any of its selectors to a sink
+type Filter struct {
+	sink      Sink
+	selectors selector.Selectors
+}
+
+func NewFilter(sink Sink, selectors selector.Selectors) *Filter {
+	return &Filter{
+		sink:      sink,
+		selectors: selectors,
+	}
+}
+
+func (f *Filter) Write(ctx context.Context, series []TimeSeries) error {
+	var matching []TimeSeries
+	for _, ts := range series {
+		if f.selectors.Matches(ts.Labels) {
+			matching = append(matching, ts)
+		}
+	}
+	if len(matching) == 0 {
+		return nil
+	}
+	return f.sink.Write(ctx, matching)
+}
+
+func (f *Filter) Flush(ctx context.Context) error {
+	return f.sink.Flush(ctx)
+}
+
+func (f *Filter) Close() error {
+	return f.sink.Close()
+}
@@ -0,0 +1,35 @@
+package sink
+
+import (
+	"context"
+	"write/graphite"
+)
+
+// Graphite sends series to a Graphite plaintext listener
+type Graphite struct {
+	client *graphite.Client
+}
+
+func NewGraphite(address string, format string) *Graphite {
+	return &Graphite{
+		client: graphite.NewClient(address, format),
+	}
+}
+
+func (g *Graphite) Write(ctx context.Context, series []TimeSeries) error {
+	wr, _ := WriteRequest(series)
+	err := g.client.Send(ctx, wr)
+	if err != nil && ctx.Err() == nil {
+		// the connection failed, there are no errors on the protocol level
+		return &RecoverableError{Err: err}
+	}
+	return err
+}
+
+func (g *Graphite) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (g *Graphite) Close() error {
+	return g.client.Close()
+}
@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecoverableError is a failure that may go away when the write is retried,
+// e.g. a network error or an overloaded receiver
+type RecoverableError struct {
+	Err error
+	// RetryAfter is the delay the receiver asked for, zero if it didn't
+	RetryAfter time.Duration
+}
+
+func (e *RecoverableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RecoverableError) Unwrap() error {
+	return e.Err
+}
+
+// FatalError is a write that failed in a way the tool must not continue
+// after, e.g. samples rejected by a receiver with the fail policy
+type FatalError struct {
+	Err error
+}
+
+func (e *FatalError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+// NewHTTPClient returns the client of the HTTP sinks, the transport adds
+// authentication and TLS settings
+func NewHTTPClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Timeout:   30 * time.Second,
+	}
+}
+
+// post sends a request body to the tenant of the context, network errors
+// are recoverable
+func post(ctx context.Context, client *http.Client, url string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if tenant := TenantFrom(ctx); tenant != "" {
+		req.Header.Set(TenantHeader, tenant)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &RecoverableError{Err: err}
+	}
+	return resp, nil
+}
+
+// closeBody reads the rest of a response body before closing it, so its
+// connection can be reused for the next request
+func closeBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+// checkResponse returns an error for unsuccessful responses. Server errors
+// and 429 Too Many Requests are recoverable, other client errors are not.
+func checkResponse(resp *http.Response, service string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := errors.New(fmt.Sprintf("unexpected %v status code: %v: %v", service, resp.StatusCode, strings.TrimSpace(string(body))))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return &RecoverableError{
+			Err:        err,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return err
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of
+// seconds or a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"write/influx"
+)
+
+// Influx sends series to an Influx write endpoint using the line protocol.
+// The url is used verbatim, e.g. http://localhost:8086/write?db=test for 1.x
+// or http://localhost:8086/api/v2/write?org=test&bucket=test for 2.x.
+type Influx struct {
+	url    string
+	client *http.Client
+}
+
+func NewInflux(url string, client *http.Client) *Influx {
+	return &Influx{
+		url:    url,
+		client: client,
+	}
+}
+
+func (i *Influx) Write(ctx context.Context, series []TimeSeries) error {
+	wr, _ := WriteRequest(series)
+	lines := influx.Lines(wr)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := post(ctx, i.client, i.url, header, lines)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	return checkResponse(resp, "influx")
+}
+
+func (i *Influx) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (i *Influx) Close() error {
+	return nil
+}
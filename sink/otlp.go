@@ -0,0 +1,56 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"write/otlp"
+)
+
+// OTLP sends series to an OTLP/HTTP metrics endpoint, e.g.
+// http://localhost:4318/v1/metrics
+type OTLP struct {
+	url       string
+	protocol  string
+	converter *otlp.Converter
+	client    *http.Client
+}
+
+func NewOTLP(url string, protocol string, resourceLabels []string, client *http.Client) *OTLP {
+	return &OTLP{
+		url:       url,
+		protocol:  protocol,
+		converter: otlp.NewConverter(resourceLabels),
+		client:    client,
+	}
+}
+
+func (o *OTLP) Write(ctx context.Context, series []TimeSeries) error {
+	wr, created := WriteRequest(series)
+	request, dropped := o.converter.Convert(wr, created)
+	if dropped > 0 {
+		log.Println(fmt.Sprintf("dropped %v incomplete histogram and summary data points", dropped))
+	}
+	data, contentType, err := otlp.Marshal(request, o.protocol)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	resp, err := post(ctx, o.client, o.url, header, data)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	return checkResponse(resp, "otlp")
+}
+
+func (o *OTLP) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (o *OTLP) Close() error {
+	return nil
+}
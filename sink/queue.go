@@ -0,0 +1,183 @@
+package sink
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// Queue batches series into requests of up to maxSamples samples and writes
+// them to a sink from several shards concurrently. Every series is always
+// written by the same shard, so its samples stay in order. The series of a
+// classic histogram or summary are written by the same shard in the same
+// batch, so receivers always get them complete.
+type Queue struct {
+	sink       Sink
+	maxSamples int
+	shards     []*shard
+
+	wg     sync.WaitGroup
+	mtx    sync.Mutex
+	errors []error
+}
+
+type shard struct {
+	mtx     sync.Mutex
+	pending []TimeSeries
+	samples int
+	batches chan []TimeSeries
+}
+
+func NewQueue(sink Sink, maxSamples int, shards int) *Queue {
+	if maxSamples < 1 {
+		maxSamples = 1
+	}
+	if shards < 1 {
+		shards = 1
+	}
+
+	q := &Queue{
+		sink:       sink,
+		maxSamples: maxSamples,
+	}
+	for i := 0; i < shards; i++ {
+		s := &shard{
+			batches: make(chan []TimeSeries, 1),
+		}
+		q.shards = append(q.shards, s)
+		go q.run(s)
+	}
+	return q
+}
+
+func (q *Queue) run(s *shard) {
+	for batch := range s.batches {
+		err := q.sink.Write(context.Background(), batch)
+		if err != nil {
+			q.mtx.Lock()
+			q.errors = append(q.errors, err)
+			q.mtx.Unlock()
+		}
+		q.wg.Done()
+	}
+}
+
+func numSamples(ts TimeSeries) int {
+	return len(ts.Samples) + len(ts.Histograms)
+}
+
+// familyKey identifies the data point a series belongs to: its name without
+// the _bucket, _sum and _count suffixes and its labels without le and
+// quantile
+func familyKey(ts TimeSeries) string {
+	var b strings.Builder
+	for _, label := range ts.Labels {
+		value := label.Value
+		switch label.Name {
+		case "le", "quantile":
+			continue
+		case "__name__":
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				value = strings.TrimSuffix(value, suffix)
+			}
+		}
+		b.WriteString(label.Name)
+		b.WriteByte(0)
+		b.WriteString(value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func shardIndex(key string, shards int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(shards))
+}
+
+// Write adds the series to the pending batches of their shards, full
+// batches are sent right away. The series of one family are added together,
+// a batch only exceeds maxSamples if a single family does.
+func (q *Queue) Write(ctx context.Context, series []TimeSeries) error {
+	var keys []string
+	families := map[string][]TimeSeries{}
+	for _, ts := range series {
+		key := familyKey(ts)
+		if _, ok := families[key]; !ok {
+			keys = append(keys, key)
+		}
+		families[key] = append(families[key], ts)
+	}
+
+	for _, key := range keys {
+		family := families[key]
+		samples := 0
+		for _, ts := range family {
+			samples += numSamples(ts)
+		}
+
+		s := q.shards[shardIndex(key, len(q.shards))]
+		s.mtx.Lock()
+		if s.samples+samples > q.maxSamples {
+			q.send(s)
+		}
+		s.pending = append(s.pending, family...)
+		s.samples += samples
+		if s.samples >= q.maxSamples {
+			q.send(s)
+		}
+		s.mtx.Unlock()
+	}
+	return nil
+}
+
+// send hands the pending batch of a shard to its goroutine, the shard has
+// to be locked
+func (q *Queue) send(s *shard) {
+	if len(s.pending) == 0 {
+		return
+	}
+	q.wg.Add(1)
+	s.batches <- s.pending
+	s.pending = nil
+	s.samples = 0
+}
+
+// Flush sends all pending batches, waits until every batch has been written
+// and flushes the sink. The errors of all writes since the last flush are
+// returned together with the error of the sink, as a FatalError if any of
+// them is one.
+func (q *Queue) Flush(ctx context.Context) error {
+	for _, s := range q.shards {
+		s.mtx.Lock()
+		q.send(s)
+		s.mtx.Unlock()
+	}
+	q.wg.Wait()
+
+	q.mtx.Lock()
+	errs := q.errors
+	q.errors = nil
+	q.mtx.Unlock()
+
+	// the sink is flushed even if batches failed, it may still hold the
+	// series of the batches that were sent
+	err := q.sink.Flush(ctx)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return joinErrors(errs)
+}
+
+func (q *Queue) Close() error {
+	err := q.Flush(context.Background())
+	for _, s := range q.shards {
+		close(s.batches)
+	}
+	closeErr := q.sink.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
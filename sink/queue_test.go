@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"sync"
+	"testing"
+)
+
+type recorder struct {
+	mtx      sync.Mutex
+	batches  [][]TimeSeries
+	writeErr error
+	flushErr error
+	flushes  int
+}
+
+func (r *recorder) Write(ctx context.Context, series []TimeSeries) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.batches = append(r.batches, series)
+	return r.writeErr
+}
+
+func (r *recorder) Flush(ctx context.Context) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.flushes++
+	return r.flushErr
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func histogramSeries(job string) []TimeSeries {
+	series := func(name string, extra ...*prometheus.Label) TimeSeries {
+		labels := append([]*prometheus.Label{{Name: "__name__", Value: name}, {Name: "job", Value: job}}, extra...)
+		return TimeSeries{TimeSeries: &prometheus.TimeSeries{
+			Labels:  labels,
+			Samples: []*prometheus.Sample{{Value: 1, Timestamp: 1000}},
+		}}
+	}
+	var result []TimeSeries
+	for _, le := range []string{"0.1", "1", "+Inf"} {
+		result = append(result, series("latency_bucket", &prometheus.Label{Name: "le", Value: le}))
+	}
+	return append(result, series("latency_sum"), series("latency_count"))
+}
+
+func TestQueueKeepsFamiliesTogether(t *testing.T) {
+	var series []TimeSeries
+	for i := 0; i < 20; i++ {
+		series = append(series, histogramSeries(fmt.Sprintf("job%v", i))...)
+	}
+	// interleave the families, they are grouped again by the queue
+	var interleaved []TimeSeries
+	for i := 0; i < 5; i++ {
+		for j := i; j < len(series); j += 5 {
+			interleaved = append(interleaved, series[j])
+		}
+	}
+
+	r := &recorder{}
+	q := NewQueue(r, 12, 3)
+	q.Write(context.Background(), interleaved)
+	err := q.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batches := map[string]int{}
+	total := 0
+	for i, batch := range r.batches {
+		if len(batch) > 12 {
+			t.Errorf("batch %v has %v samples, expected at most 12", i, len(batch))
+		}
+		for _, ts := range batch {
+			key := familyKey(ts)
+			if previous, ok := batches[key]; ok && previous != i {
+				t.Errorf("family %q is split across batches %v and %v", key, previous, i)
+			}
+			batches[key] = i
+			total++
+		}
+	}
+	if total != len(series) || len(batches) != 20 {
+		t.Errorf("expected %v series of 20 families, got %v of %v", len(series), total, len(batches))
+	}
+}
+
+func TestQueueFlushesSinkAfterErrors(t *testing.T) {
+	r := &recorder{writeErr: errors.New("rejected"), flushErr: errors.New("unavailable")}
+	q := NewQueue(r, 100, 1)
+	q.Write(context.Background(), histogramSeries("api"))
+	err := q.Flush(context.Background())
+	if r.flushes != 1 {
+		t.Errorf("expected the sink to be flushed once, got %v", r.flushes)
+	}
+	if err == nil || err.Error() != "rejected; unavailable" {
+		t.Errorf("expected the write and the flush error, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"context"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"write/relabel"
+)
+
+// Relabel applies relabeling rules to the series written to a sink. The
+// series are copied, other sinks still get the original labels.
+type Relabel struct {
+	sink  Sink
+	rules relabel.Rules
+}
+
+func NewRelabel(sink Sink, rules relabel.Rules) *Relabel {
+	return &Relabel{
+		sink:  sink,
+		rules: rules,
+	}
+}
+
+func (r *Relabel) Write(ctx context.Context, series []TimeSeries) error {
+	var relabeled []TimeSeries
+	for _, ts := range series {
+		labels, keep := r.rules.Apply(ts.Labels)
+		if !keep {
+			continue
+		}
+		copied := &prometheus.TimeSeries{
+			Labels:     labels,
+			Samples:    ts.Samples,
+			Exemplars:  ts.Exemplars,
+			Histograms: ts.Histograms,
+		}
+		relabeled = append(relabeled, TimeSeries{
+			TimeSeries: copied,
+			Metadata:   ts.Metadata,
+			Created:    ts.Created,
+			Tenant:     ts.Tenant,
+		})
+	}
+	if len(relabeled) == 0 {
+		return nil
+	}
+	return r.sink.Write(ctx, relabeled)
+}
+
+func (r *Relabel) Flush(ctx context.Context) error {
+	return r.sink.Flush(ctx)
+}
+
+func (r *Relabel) Close() error {
+	return r.sink.Close()
+}
@@ -0,0 +1,139 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"write/remotewrite"
+)
+
+// RemoteWrite sends series to a Prometheus remote write endpoint. Samples
+// rejected with 400 Bad Request are ignored, reported or fail the write with
+// a FatalError depending on the reason and the policy.
+type RemoteWrite struct {
+	url      *url.URL
+	protocol string
+	policy   remotewrite.Policy
+	client   *http.Client
+
+	unconfirmed sync.Once
+}
+
+func NewRemoteWrite(url *url.URL, protocol string, policy remotewrite.Policy, client *http.Client) *RemoteWrite {
+	return &RemoteWrite{
+		url:      url,
+		protocol: protocol,
+		policy:   policy,
+		client:   client,
+	}
+}
+
+func (r *RemoteWrite) Write(ctx context.Context, series []TimeSeries) error {
+	wr, created := WriteRequest(series)
+
+	var data []byte
+	if r.protocol == remotewrite.ProtocolV2 {
+		data = remotewrite.MarshalV2(wr, created)
+	} else {
+		data, _ = proto.Marshal(wr)
+	}
+	encoded := snappy.Encode(nil, data)
+
+	header := http.Header{}
+	header.Set("Content-Type", remotewrite.ContentType(r.protocol))
+	header.Set("Content-Encoding", "snappy")
+	header.Set("X-Prometheus-Remote-Write-Version", remotewrite.Version(r.protocol))
+
+	resp, err := post(ctx, r.client, r.url.String(), header, encoded)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp)
+	if resp.StatusCode == http.StatusBadRequest {
+		return r.rejected(resp, series)
+	}
+	err = checkResponse(resp, "remote write")
+	if err != nil {
+		return err
+	}
+
+	if r.protocol == remotewrite.ProtocolV2 {
+		r.checkWriteStats(remotewrite.ParseWriteStats(resp.Header), series)
+	}
+
+	return nil
+}
+
+// checkWriteStats logs the written samples reported by a Remote Write 2.0
+// receiver if they differ from those that were sent
+func (r *RemoteWrite) checkWriteStats(stats remotewrite.WriteStats, series []TimeSeries) {
+	if !stats.Confirmed {
+		r.unconfirmed.Do(func() {
+			log.Println("receiver did not confirm written samples, it may not support remote write 2.0")
+		})
+		return
+	}
+
+	sent := remotewrite.WriteStats{}
+	for _, ts := range series {
+		sent.Samples += int64(len(ts.Samples))
+		sent.Histograms += int64(len(ts.Histograms))
+		sent.Exemplars += int64(len(ts.Exemplars))
+	}
+	if stats.Samples != sent.Samples || stats.Histograms != sent.Histograms || stats.Exemplars != sent.Exemplars {
+		log.Println(fmt.Sprintf("receiver wrote samples: %v of %v, histograms: %v of %v, exemplars: %v of %v", stats.Samples, sent.Samples, stats.Histograms, sent.Histograms, stats.Exemplars, sent.Exemplars))
+	}
+}
+
+// rejected applies the policy to a 400 response. The rejected series are
+// reported as quoted by the receiver, or all series of the request if it
+// doesn't quote any.
+func (r *RemoteWrite) rejected(resp *http.Response, series []TimeSeries) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	message := strings.TrimSpace(string(body))
+	rejection := remotewrite.Classify(message)
+	action := r.policy[rejection]
+	if action == remotewrite.ActionIgnore {
+		return nil
+	}
+	if action == remotewrite.ActionFail {
+		return &FatalError{Err: errors.New(fmt.Sprintf("samples rejected as %v: %v", rejection, message))}
+	}
+
+	rejectedSeries := remotewrite.RejectedSeries(message)
+	if len(rejectedSeries) == 0 {
+		for _, ts := range series {
+			rejectedSeries = append(rejectedSeries, seriesString(ts))
+		}
+	}
+	for _, s := range rejectedSeries {
+		log.Println(fmt.Sprintf("samples of %v rejected as %v", s, rejection))
+	}
+	log.Println(fmt.Sprintf("receiver response: %v", message))
+	return nil
+}
+
+func seriesString(ts TimeSeries) string {
+	var pairs []string
+	for _, label := range ts.Labels {
+		pairs = append(pairs, fmt.Sprintf("%v=%q", label.Name, label.Value))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func (r *RemoteWrite) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (r *RemoteWrite) Close() error {
+	return nil
+}
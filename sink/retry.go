@@ -0,0 +1,139 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// RetryStats counts the samples of retried and dropped writes. It can be
+// shared by several sinks.
+type RetryStats struct {
+	RetriedRequests atomic.Int64
+	RetriedSamples  atomic.Int64
+	DroppedSamples  atomic.Int64
+}
+
+func (s *RetryStats) String() string {
+	return fmt.Sprintf("retried requests: %v, retried samples: %v, dropped samples: %v", s.RetriedRequests.Load(), s.RetriedSamples.Load(), s.DroppedSamples.Load())
+}
+
+// WriteRequest returns the current counts as counter series, e.g. to expose
+// them to be scraped
+func (s *RetryStats) WriteRequest(timestamp int64) *prometheus.WriteRequest {
+	wr := &prometheus.WriteRequest{}
+	for _, counter := range []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"write_requests_retried_total", "Number of requests that were retried.", s.RetriedRequests.Load()},
+		{"write_samples_retried_total", "Number of samples in requests that were retried.", s.RetriedSamples.Load()},
+		{"write_samples_dropped_total", "Number of samples that were dropped after failing.", s.DroppedSamples.Load()},
+	} {
+		wr.Timeseries = append(wr.Timeseries, &prometheus.TimeSeries{
+			Labels:  []*prometheus.Label{{Name: "__name__", Value: counter.name}},
+			Samples: []*prometheus.Sample{{Value: float64(counter.value), Timestamp: timestamp}},
+		})
+		wr.Metadata = append(wr.Metadata, &prometheus.MetricMetadata{
+			MetricFamilyName: counter.name,
+			Type:             prometheus.MetricMetadata_COUNTER,
+			Help:             counter.help,
+		})
+	}
+	return wr
+}
+
+// Retry retries the recoverable failures of a sink with an exponential
+// backoff. The backoff doubles from minBackoff up to maxBackoff with a random
+// jitter of up to half of it, unless the receiver asked for a delay with
+// Retry-After. Writes that still fail after maxDuration are dropped and
+// their last error is returned, a maxDuration of zero disables retries.
+// Failures that aren't recoverable are returned right away.
+type Retry struct {
+	sink        Sink
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxDuration time.Duration
+	stats       *RetryStats
+}
+
+// minRetryBackoff is the shortest delay between retries, a zero backoff
+// would never grow
+const minRetryBackoff = time.Millisecond
+
+func NewRetry(sink Sink, minBackoff time.Duration, maxBackoff time.Duration, maxDuration time.Duration, stats *RetryStats) *Retry {
+	if minBackoff < minRetryBackoff {
+		minBackoff = minRetryBackoff
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+	return &Retry{
+		sink:        sink,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+		maxDuration: maxDuration,
+		stats:       stats,
+	}
+}
+
+func (r *Retry) Write(ctx context.Context, series []TimeSeries) error {
+	samples := 0
+	for _, ts := range series {
+		samples += numSamples(ts)
+	}
+
+	start := time.Now()
+	backoff := r.minBackoff
+	for {
+		err := r.sink.Write(ctx, series)
+		if err == nil {
+			return nil
+		}
+
+		var recoverable *RecoverableError
+		if !errors.As(err, &recoverable) {
+			r.stats.DroppedSamples.Add(int64(samples))
+			return err
+		}
+
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+		if recoverable.RetryAfter > 0 {
+			delay = recoverable.RetryAfter
+		}
+
+		if time.Since(start)+delay > r.maxDuration {
+			r.stats.DroppedSamples.Add(int64(samples))
+			return errors.New(fmt.Sprintf("dropped %v samples after retrying for %v: %v", samples, time.Since(start).Round(time.Millisecond), err))
+		}
+
+		r.stats.RetriedRequests.Add(1)
+		r.stats.RetriedSamples.Add(int64(samples))
+		log.Println(fmt.Sprintf("retrying %v samples in %v: %v", samples, delay.Round(time.Millisecond), err))
+		select {
+		case <-ctx.Done():
+			r.stats.DroppedSamples.Add(int64(samples))
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+func (r *Retry) Flush(ctx context.Context) error {
+	return r.sink.Flush(ctx)
+}
+
+func (r *Retry) Close() error {
+	return r.sink.Close()
+}
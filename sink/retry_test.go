@@ -0,0 +1,60 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failing fails the first failures writes with a recoverable error
+type failing struct {
+	failures int
+	writes   int
+}
+
+func (f *failing) Write(ctx context.Context, series []TimeSeries) error {
+	f.writes++
+	if f.writes <= f.failures {
+		return &RecoverableError{Err: errors.New("unavailable")}
+	}
+	return nil
+}
+
+func (f *failing) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (f *failing) Close() error {
+	return nil
+}
+
+func TestRetryReturnsLastError(t *testing.T) {
+	for _, maxDuration := range []time.Duration{0, 20 * time.Millisecond} {
+		f := &failing{failures: 1000}
+		stats := &RetryStats{}
+		err := NewRetry(f, 0, 0, maxDuration, stats).Write(context.Background(), histogramSeries("api"))
+		if err == nil || !strings.Contains(err.Error(), "unavailable") {
+			t.Errorf("max duration %v: expected the last error, got %v", maxDuration, err)
+		}
+		if stats.DroppedSamples.Load() != 5 {
+			t.Errorf("max duration %v: expected 5 dropped samples, got %v", maxDuration, stats.DroppedSamples.Load())
+		}
+		if maxDuration == 0 && f.writes != 1 {
+			t.Errorf("expected a single write without retries, got %v", f.writes)
+		}
+	}
+}
+
+func TestRetryRecovers(t *testing.T) {
+	f := &failing{failures: 3}
+	stats := &RetryStats{}
+	err := NewRetry(f, 0, time.Millisecond, time.Second, stats).Write(context.Background(), histogramSeries("api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.writes != 4 || stats.RetriedRequests.Load() != 3 || stats.DroppedSamples.Load() != 0 {
+		t.Errorf("expected 4 writes and 3 retries, got %v writes, %v", f.writes, stats)
+	}
+}
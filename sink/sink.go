@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"strings"
+	"write/remotewrite"
+)
+
+// TimeSeries is a series together with the metadata of its metric family
+type TimeSeries struct {
+	*prometheus.TimeSeries
+	Metadata *prometheus.MetricMetadata
+	// Created is the created timestamp in milliseconds, the start of
+	// counters, histograms and summaries
+	Created int64
+	// Tenant is the tenant the series is written to, empty for none
+	Tenant string
+}
+
+// Sink is a destination for generated series
+type Sink interface {
+	// Write sends the samples of the series
+	Write(ctx context.Context, series []TimeSeries) error
+	// Flush sends series that have been written but not sent yet
+	Flush(ctx context.Context) error
+	// Close releases the resources of the sink, it is flushed first
+	Close() error
+}
+
+// FromWriteRequest attaches the metadata of a remote write request, the
+// created timestamp and the tenant to its series
+func FromWriteRequest(wr *prometheus.WriteRequest, created int64, tenant string) []TimeSeries {
+	var series []TimeSeries
+	for _, ts := range wr.Timeseries {
+		series = append(series, TimeSeries{
+			TimeSeries: ts,
+			Metadata:   remotewrite.FindMetadata(wr.Metadata, ts.Labels),
+			Created:    created,
+			Tenant:     tenant,
+		})
+	}
+	return series
+}
+
+// WriteRequest builds a remote write request from series and returns the
+// created timestamp of each of its series
+func WriteRequest(series []TimeSeries) (*prometheus.WriteRequest, []int64) {
+	wr := &prometheus.WriteRequest{}
+	var created []int64
+	families := map[string]bool{}
+	for _, ts := range series {
+		wr.Timeseries = append(wr.Timeseries, ts.TimeSeries)
+		created = append(created, ts.Created)
+		if ts.Metadata != nil && !families[ts.Metadata.MetricFamilyName] {
+			families[ts.Metadata.MetricFamilyName] = true
+			wr.Metadata = append(wr.Metadata, ts.Metadata)
+		}
+	}
+	return wr, created
+}
+
+// Fanout writes series to several sinks. All sinks are written to even if
+// some of them fail.
+type Fanout struct {
+	sinks []Sink
+}
+
+func NewFanout(sinks ...Sink) *Fanout {
+	return &Fanout{
+		sinks: sinks,
+	}
+}
+
+func (f *Fanout) Write(ctx context.Context, series []TimeSeries) error {
+	return f.each(func(s Sink) error {
+		return s.Write(ctx, series)
+	})
+}
+
+func (f *Fanout) Flush(ctx context.Context) error {
+	return f.each(func(s Sink) error {
+		return s.Flush(ctx)
+	})
+}
+
+func (f *Fanout) Close() error {
+	return f.each(func(s Sink) error {
+		return s.Close()
+	})
+}
+
+func (f *Fanout) each(fn func(s Sink) error) error {
+	var errs []error
+	for _, s := range f.sinks {
+		err := fn(s)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+// joinErrors combines the errors of several writes into one, it is a
+// FatalError if any of them is
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	var messages []string
+	fatal := false
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+		var fatalErr *FatalError
+		if errors.As(err, &fatalErr) {
+			fatal = true
+		}
+	}
+	err := errors.New(strings.Join(messages, "; "))
+	if fatal {
+		return &FatalError{Err: err}
+	}
+	return err
+}
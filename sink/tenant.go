@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"context"
+)
+
+// TenantHeader routes writes to a tenant in Mimir, Cortex and Loki
+const TenantHeader = "X-Scope-OrgID"
+
+type tenantKey struct{}
+
+// WithTenant returns a context that sends writes to a tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of a context, an empty string if there is
+// none
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// Tenants splits writes into one write per tenant, so that the series of
+// different tenants are never sent in the same request
+type Tenants struct {
+	sink Sink
+}
+
+func NewTenants(sink Sink) *Tenants {
+	return &Tenants{
+		sink: sink,
+	}
+}
+
+func (t *Tenants) Write(ctx context.Context, series []TimeSeries) error {
+	var tenants []string
+	groups := map[string][]TimeSeries{}
+	for _, ts := range series {
+		if _, ok := groups[ts.Tenant]; !ok {
+			tenants = append(tenants, ts.Tenant)
+		}
+		groups[ts.Tenant] = append(groups[ts.Tenant], ts)
+	}
+
+	var errs []error
+	for _, tenant := range tenants {
+		err := t.sink.Write(WithTenant(ctx, tenant), groups[tenant])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+func (t *Tenants) Flush(ctx context.Context) error {
+	return t.sink.Flush(ctx)
+}
+
+func (t *Tenants) Close() error {
+	return t.sink.Close()
+}
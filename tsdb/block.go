@@ -0,0 +1,144 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"path/filepath"
+	"sort"
+	"time"
+
+	ptsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+type blockSeries struct {
+	labels  labels.Labels
+	samples []sample
+}
+
+// WriteBlocks writes the float samples of the write requests into TSDB
+// blocks in the directory, one block for every block duration that contains
+// samples. Blocks are aligned to the block duration like the blocks
+// Prometheus cuts itself. Native histograms are not supported and skipped.
+// It returns the directories of the blocks written.
+func WriteBlocks(dir string, requests []prometheus.WriteRequest, blockDuration time.Duration) ([]string, error) {
+	blockRange := blockDuration.Milliseconds()
+	if blockRange <= 0 {
+		return nil, errors.New("block duration must be at least one millisecond")
+	}
+
+	// samples of the same series from different requests are merged and
+	// split up by the block they belong to
+	blocks := map[int64]map[string]*blockSeries{}
+	for _, wr := range requests {
+		for _, ts := range wr.Timeseries {
+			var lset labels.Labels
+			for _, l := range ts.Labels {
+				lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+			}
+			sort.Sort(lset)
+			key := lset.String()
+
+			for _, s := range ts.Samples {
+				block := s.Timestamp / blockRange
+				if s.Timestamp < 0 && s.Timestamp%blockRange != 0 {
+					block--
+				}
+				if _, ok := blocks[block]; !ok {
+					blocks[block] = map[string]*blockSeries{}
+				}
+				series, ok := blocks[block][key]
+				if !ok {
+					series = &blockSeries{labels: lset}
+					blocks[block][key] = series
+				}
+				series.samples = append(series.samples, sample{t: s.Timestamp, v: s.Value})
+			}
+		}
+	}
+
+	var ranges []int64
+	for block := range blocks {
+		ranges = append(ranges, block)
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i] < ranges[j]
+	})
+
+	compactor, err := ptsdb.NewLeveledCompactor(context.Background(), nil, nil, []int64{blockRange}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+	for _, block := range ranges {
+		var series []*blockSeries
+		for _, s := range blocks[block] {
+			series = append(series, s)
+		}
+		blockDir, err := writeBlock(dir, compactor, block*blockRange, blockRange, series)
+		if err != nil {
+			return written, err
+		}
+		written = append(written, blockDir)
+	}
+	return written, nil
+}
+
+// writeBlock appends the series to an in-memory head and lets the compactor
+// persist it as a block covering [mint, mint+blockRange)
+func writeBlock(dir string, compactor *ptsdb.LeveledCompactor, mint int64, blockRange int64, series []*blockSeries) (string, error) {
+	for _, s := range series {
+		sort.SliceStable(s.samples, func(i, j int) bool {
+			return s.samples[i].t < s.samples[j].t
+		})
+	}
+	// the head rejects samples more than half its range older than the
+	// first one appended, starting with the oldest sample keeps all of
+	// them appendable
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].samples[0].t < series[j].samples[0].t
+	})
+
+	head, err := ptsdb.NewHead(nil, nil, nil, blockRange)
+	if err != nil {
+		return "", err
+	}
+	defer head.Close()
+	err = head.Init(mint)
+	if err != nil {
+		return "", err
+	}
+
+	app := head.Appender()
+	for _, s := range series {
+		for j, smpl := range s.samples {
+			if j+1 < len(s.samples) && s.samples[j+1].t == smpl.t {
+				// the last sample for a timestamp wins
+				continue
+			}
+			_, err = app.Add(s.labels, smpl.t, smpl.v)
+			if err != nil {
+				app.Rollback()
+				return "", errors.New(fmt.Sprintf("error adding sample of %v at %v: %v", s.labels, smpl.t, err))
+			}
+		}
+	}
+	err = app.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := compactor.Write(dir, head, mint, mint+blockRange, nil)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id.String()), nil
+}
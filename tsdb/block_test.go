@@ -0,0 +1,151 @@
+package tsdb
+
+import (
+	"go.buf.build/protocolbuffers/go/prometheus/prometheus"
+	"math"
+	"testing"
+	"time"
+
+	ptsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestWriteBlocksReadByPrometheus(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	staleNaN := math.Float64frombits(0x7ff0000000000002)
+
+	// enough samples for several chunks with irregular values and intervals
+	// to exercise the XOR encoding
+	var up, temperature []*prometheus.Sample
+	for i := 0; i < 300; i++ {
+		timestamp := start + int64(i)*15000 + int64(i%7)
+		up = append(up, &prometheus.Sample{Value: float64(i % 2), Timestamp: timestamp})
+		value := math.Sin(float64(i)) * 1000
+		switch i {
+		case 13:
+			value = math.Inf(1)
+		case 42:
+			value = -0.000123
+		case 299:
+			value = staleNaN
+		}
+		temperature = append(temperature, &prometheus.Sample{Value: value, Timestamp: timestamp})
+	}
+	requests := []prometheus.WriteRequest{{
+		Timeseries: []*prometheus.TimeSeries{
+			{
+				Labels:  []*prometheus.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+				Samples: up,
+			},
+			{
+				Labels:  []*prometheus.Label{{Name: "job", Value: "api"}, {Name: "__name__", Value: "temperature"}},
+				Samples: temperature,
+			},
+		},
+	}}
+
+	dirs, err := WriteBlocks(t.TempDir(), requests, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 {
+		t.Fatalf("expected one block, got %v", dirs)
+	}
+
+	block, err := ptsdb.OpenBlock(nil, dirs[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer block.Close()
+	if block.Meta().Stats.NumSeries != 2 || block.Meta().Stats.NumSamples != 600 {
+		t.Errorf("unexpected stats: %+v", block.Meta().Stats)
+	}
+	if block.Meta().MinTime != start || block.Meta().MaxTime != start+24*time.Hour.Milliseconds() {
+		t.Errorf("expected the block to cover the day, got %v to %v", block.Meta().MinTime, block.Meta().MaxTime)
+	}
+
+	querier, err := ptsdb.NewBlockQuerier(block, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer querier.Close()
+
+	for name, expected := range map[string][]*prometheus.Sample{"up": up, "temperature": temperature} {
+		set, err := querier.Select(labels.NewEqualMatcher("__name__", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !set.Next() {
+			t.Fatalf("series %v not found", name)
+		}
+		series := set.At()
+		if series.Labels().Get("job") != "api" {
+			t.Errorf("unexpected labels of %v: %v", name, series.Labels())
+		}
+
+		it := series.Iterator()
+		i := 0
+		for it.Next() {
+			timestamp, value := it.At()
+			if i >= len(expected) {
+				t.Fatalf("%v: more samples than written", name)
+			}
+			if timestamp != expected[i].Timestamp || math.Float64bits(value) != math.Float64bits(expected[i].Value) {
+				t.Errorf("%v sample %v: expected %v@%v, got %v@%v", name, i, expected[i].Value, expected[i].Timestamp, value, timestamp)
+			}
+			i++
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		if i != len(expected) {
+			t.Errorf("%v: expected %v samples, got %v", name, len(expected), i)
+		}
+		if set.Next() {
+			t.Errorf("more than one series named %v", name)
+		}
+	}
+}
+
+func TestWriteBlocksAligned(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	hour := time.Hour.Milliseconds()
+
+	// the second series starts before the first one and both cross the
+	// boundary of the first block
+	requests := []prometheus.WriteRequest{{
+		Timeseries: []*prometheus.TimeSeries{
+			{
+				Labels:  []*prometheus.Label{{Name: "__name__", Value: "late"}},
+				Samples: []*prometheus.Sample{{Value: 1, Timestamp: start + 90*time.Minute.Milliseconds()}, {Value: 2, Timestamp: start + 3*hour}},
+			},
+			{
+				Labels:  []*prometheus.Label{{Name: "__name__", Value: "early"}},
+				Samples: []*prometheus.Sample{{Value: 1, Timestamp: start + hour}, {Value: 2, Timestamp: start + 2*hour}},
+			},
+		},
+	}}
+
+	dirs, err := WriteBlocks(t.TempDir(), requests, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 2 {
+		t.Fatalf("expected two blocks, got %v", dirs)
+	}
+	for i, dir := range dirs {
+		block, err := ptsdb.OpenBlock(nil, dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := block.Meta()
+		block.Close()
+		mint := start + int64(i)*2*hour
+		if meta.MinTime != mint || meta.MaxTime != mint+2*hour {
+			t.Errorf("block %v: expected %v to %v, got %v to %v", i, mint, mint+2*hour, meta.MinTime, meta.MaxTime)
+		}
+		if meta.Stats.NumSeries != 2 || meta.Stats.NumSamples != 2 {
+			t.Errorf("block %v: unexpected stats %+v", i, meta.Stats)
+		}
+	}
+}